@@ -0,0 +1,21 @@
+package main
+
+import (
+	"net/http"
+)
+
+// 账号活动日志中记录的事件
+const (
+	activityPasswordChanged = "password_changed"
+	activityPasswordReset   = "password_reset"
+	activityNameChanged     = "name_changed"
+	activityEmailChanged    = "email_changed"
+)
+
+// recordActivity 在账号活动日志中记录一次操作，记录失败不影响操作本身，所以只写入错误日志
+func (app *application) recordActivity(r *http.Request, userID int, event string) {
+	err := app.activity.Insert(userID, clientIP(r), event)
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+}
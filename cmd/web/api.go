@@ -0,0 +1,403 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/forms"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"math"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// apiMaxBodyBytes 限制API请求体的大小
+const apiMaxBodyBytes = 1 << 20
+
+// apiMaxLimit 是列表接口每页最多返回的snippet数量
+const apiMaxLimit = 100
+
+// snippetJSON 是snippet在API中的表示
+type snippetJSON struct {
+	ID               int       `json:"id"`
+	UserID           int       `json:"user_id"`
+	Author           string    `json:"author"`
+	Title            string    `json:"title"`
+	Content          string    `json:"content"`
+	Format           string    `json:"format"`
+	Language         string    `json:"language"`
+	Visibility       string    `json:"visibility"`
+	Slug             string    `json:"slug"`
+	BurnAfterReading bool      `json:"burn_after_reading"`
+	Protected        bool      `json:"protected"`
+	Tags             []string  `json:"tags"`
+	Created          time.Time `json:"created"`
+	Expires          time.Time `json:"expires"`
+}
+
+func newSnippetJSON(s *models.Snippet) *snippetJSON {
+	tags := s.Tags
+	if tags == nil {
+		tags = []string{}
+	}
+	return &snippetJSON{
+		ID:               s.ID,
+		UserID:           s.UserID,
+		Author:           s.UserName,
+		Title:            s.Title,
+		Content:          s.Content,
+		Format:           s.Format,
+		Language:         s.Language,
+		Visibility:       s.Visibility,
+		Slug:             s.Slug,
+		BurnAfterReading: s.BurnAfterReading,
+		Protected:        s.Protected,
+		Tags:             tags,
+		Created:          s.Created,
+		Expires:          s.Expires,
+	}
+}
+
+// snippetInput 是创建和修改snippet时的请求体，修改时忽略Expires
+// 修改时没有Visibility、Format或者Language字段的，保持原来的设置
+type snippetInput struct {
+	Title      string   `json:"title"`
+	Content    string   `json:"content"`
+	Format     string   `json:"format"`
+	Language   string   `json:"language"`
+	Visibility string   `json:"visibility"`
+	Tags       []string `json:"tags"`
+	Expires    int      `json:"expires"`
+}
+
+// form 把请求体转换为表单，这样可以复用网页表单的检验规则
+func (in *snippetInput) form() *forms.Form {
+	data := url.Values{}
+	data.Set("title", in.Title)
+	data.Set("content", in.Content)
+	data.Set("format", in.Format)
+	data.Set("language", in.Language)
+	data.Set("visibility", in.Visibility)
+	data.Set("tags", strings.Join(in.Tags, ","))
+	if in.Expires != 0 {
+		data.Set("expires", strconv.Itoa(in.Expires))
+	}
+	return forms.New(data)
+}
+
+// writeJSON 把v编码为JSON并以给定的状态码写入响应
+func (app *application) writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	js, err := json.Marshal(v)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(status)
+	w.Write(js)
+	w.Write([]byte("\n"))
+}
+
+// apiError 返回形如{"error": "..."}的错误响应
+func (app *application) apiError(w http.ResponseWriter, status int, message string) {
+	app.writeJSON(w, status, map[string]string{"error": message})
+}
+
+// apiServerError 与serverError相同，但是以JSON格式返回
+func (app *application) apiServerError(w http.ResponseWriter, err error) {
+	trace := fmt.Sprintf("%s\n%s", err.Error(), debug.Stack())
+	app.errorLog.Output(2, trace)
+
+	app.apiError(w, http.StatusInternalServerError, http.StatusText(http.StatusInternalServerError))
+}
+
+// apiValidationError 以422状态码返回表单检验的错误，每个字段对应一组错误信息
+func (app *application) apiValidationError(w http.ResponseWriter, form *forms.Form) {
+	app.writeJSON(w, http.StatusUnprocessableEntity, map[string]interface{}{"errors": form.Errors})
+}
+
+// readJSON 解析请求体到dst中，不允许出现未知字段
+// 如果出现任何问题，已经向客户端写入了错误响应，返回false
+func (app *application) readJSON(w http.ResponseWriter, r *http.Request, dst interface{}) bool {
+	r.Body = http.MaxBytesReader(w, r.Body, apiMaxBodyBytes)
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+
+	if err := dec.Decode(dst); err != nil {
+		app.apiError(w, http.StatusBadRequest, "Invalid JSON body: "+err.Error())
+		return false
+	}
+	// 请求体中只能有一个JSON值
+	if dec.More() {
+		app.apiError(w, http.StatusBadRequest, "Invalid JSON body: unexpected data after value")
+		return false
+	}
+	return true
+}
+
+// apiAuthenticate 通过HTTP Basic认证识别API用户，用户名为注册邮箱
+// 没有提供认证信息时作为匿名用户继续处理，认证信息错误时返回401
+func (app *application) apiAuthenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		email, password, ok := r.BasicAuth()
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		// 与网页登录使用相同的失败次数限制
+		ip := clientIP(r)
+		wait, err := app.loginAttempt(email, ip)
+		if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+		if wait > 0 {
+			app.auditLogin(email, ip, loginLocked)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			app.apiError(w, http.StatusTooManyRequests, "Too many failed attempts")
+			return
+		}
+
+		id, err := app.users.Authenticate(email, password)
+		if err == models.ErrInvalidCredentials {
+			app.auditLogin(email, ip, loginFailure)
+			app.apiUnauthorized(w, "Invalid credentials")
+			return
+		} else if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+
+		user, err := app.users.Get(id)
+		if err == models.ErrNoRecord {
+			app.apiUnauthorized(w, "Invalid credentials")
+			return
+		} else if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+
+		// 开启了两步验证的用户只凭密码不能访问，需要使用API令牌
+		_, enabled, err := app.twoFactor.Secret(id)
+		if err != nil && err != models.ErrNoRecord {
+			app.apiServerError(w, err)
+			return
+		}
+		if enabled {
+			// 密码是正确的，但不能清除失败记录，否则可以借此重置验证码的失败次数
+			if err := app.loginPassed(email, ip); err != nil {
+				app.apiServerError(w, err)
+				return
+			}
+			app.apiUnauthorized(w, "Two-factor authentication is enabled; use an API token")
+			return
+		}
+		if err := app.loginSucceeded(email, ip); err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyUser, user)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireAPIUser 与requireAuthenticatedUser相同，但是返回401而不是重定向到登录页面
+func (app *application) requireAPIUser(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.authenticatedUser(r) == nil {
+			app.apiUnauthorized(w, "Authentication required")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiUnauthorized 返回401，并告诉客户端可以使用API令牌或者HTTP Basic认证
+func (app *application) apiUnauthorized(w http.ResponseWriter, message string) {
+	w.Header().Add("WWW-Authenticate", `Bearer realm="snippetbox"`)
+	w.Header().Add("WWW-Authenticate", `Basic realm="snippetbox", charset="UTF-8"`)
+	app.apiError(w, http.StatusUnauthorized, message)
+}
+
+// apiSnippetFromURL 与snippetFromURL相同，但是以JSON格式返回错误
+// 与网页一样使用slug而不是数字id取出snippet，不公开的snippet不能通过递增的id被枚举
+func (app *application) apiSnippetFromURL(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"), app.viewerID(r))
+	if err == models.ErrNoRecord {
+		app.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil, false
+	} else if err != nil {
+		app.apiServerError(w, err)
+		return nil, false
+	}
+
+	// 阅后即焚的snippet只能在网页上确认之后查看，API只对作者开放
+	if s.BurnAfterReading && !app.isOwner(r, s) {
+		app.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return nil, false
+	}
+	// API没有输入密码的方式，有密码的snippet只有作者可以通过API访问
+	if s.Protected && !app.isOwner(r, s) {
+		app.apiError(w, http.StatusForbidden, "This snippet is password protected")
+		return nil, false
+	}
+
+	return s, true
+}
+
+// apiOwnedSnippet 在apiSnippetFromURL的基础上检查当前用户是否为作者
+func (app *application) apiOwnedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.apiSnippetFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if s.UserID != app.authenticatedUser(r).ID {
+		app.apiError(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+		return nil, false
+	}
+
+	return s, true
+}
+
+// GET /api/v1/snippets 分页列出最新的snippet，使用after和before游标翻页
+func (app *application) apiListSnippets(w http.ResponseWriter, r *http.Request) {
+	cursor, err := pageCursor(r)
+	if err != nil {
+		app.apiError(w, http.StatusBadRequest, "Invalid cursor")
+		return
+	}
+
+	limit := app.pageSize
+	if s := r.URL.Query().Get("limit"); s != "" {
+		limit, err = strconv.Atoi(s)
+		if err != nil || limit < 1 || limit > apiMaxLimit {
+			app.apiError(w, http.StatusBadRequest, fmt.Sprintf("Invalid limit (must be between 1 and %d)", apiMaxLimit))
+			return
+		}
+	}
+
+	page, err := app.snippets.List(cursor, limit)
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	snippets := make([]*snippetJSON, 0, len(page.Snippets))
+	for _, s := range page.Snippets {
+		snippets = append(snippets, newSnippetJSON(s))
+	}
+	// 没有上一页或下一页时对应的游标为空字符串
+	var next, prev string
+	if page.Next != nil {
+		next = page.Next.String()
+	}
+	if page.Prev != nil {
+		prev = page.Prev.String()
+	}
+
+	app.writeJSON(w, http.StatusOK, map[string]interface{}{
+		"snippets": snippets,
+		"next":     next,
+		"prev":     prev,
+	})
+}
+
+// GET /api/v1/snippets/:slug
+func (app *application) apiShowSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.apiSnippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, newSnippetJSON(s))
+}
+
+// POST /api/v1/snippets 创建snippet，成功时返回201和新snippet的地址
+func (app *application) apiCreateSnippet(w http.ResponseWriter, r *http.Request) {
+	var in snippetInput
+	if !app.readJSON(w, r, &in) {
+		return
+	}
+
+	form := in.form()
+	form.Required("expires")
+	form.PermittedValues("expires", "365", "7", "1")
+	validateSnippetForm(form)
+	if !form.Valid() {
+		app.apiValidationError(w, form)
+		return
+	}
+
+	s := &models.Snippet{UserID: app.authenticatedUser(r).ID, Visibility: models.VisibilityPublic}
+	fillSnippet(s, form)
+	id, err := app.snippets.Insert(s, form.Get("expires"))
+	if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Location", "/api/v1/snippets/"+s.Slug)
+	app.writeJSON(w, http.StatusCreated, map[string]interface{}{"id": id, "slug": s.Slug})
+}
+
+// PUT /api/v1/snippets/:slug 修改snippet，只有作者可以修改，过期时间保持不变
+func (app *application) apiUpdateSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// 先填入原来的格式和语言，请求体中没有这两个字段时不会被fillSnippet重置
+	in := snippetInput{Format: s.Format, Language: s.Language}
+	if !app.readJSON(w, r, &in) {
+		return
+	}
+
+	form := in.form()
+	validateSnippetForm(form)
+	validateFormatChange(form, s)
+	if !form.Valid() {
+		app.apiValidationError(w, form)
+		return
+	}
+
+	fillSnippet(s, form)
+	err := app.snippets.Update(s)
+	if err == models.ErrNoRecord {
+		app.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	} else if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	app.writeJSON(w, http.StatusOK, newSnippetJSON(s))
+}
+
+// DELETE /api/v1/snippets/:slug 删除snippet，只有作者可以删除
+func (app *application) apiDeleteSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.apiOwnedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(s.ID)
+	if err == models.ErrNoRecord {
+		app.apiError(w, http.StatusNotFound, http.StatusText(http.StatusNotFound))
+		return
+	} else if err != nil {
+		app.apiServerError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"testing"
+)
+
+func TestAPIShowSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		header   http.Header
+		wantCode int
+		wantBody []byte
+	}{
+		{"Valid slug", "/api/v1/snippets/r7Gx2kQpLm9w", nil, http.StatusOK, []byte(`"title":"An old silent pond"`)},
+		{"Non-existent slug", "/api/v1/snippets/n2Wb6yRfEo7s", nil, http.StatusNotFound, []byte(`{"error":"Not Found"}`)},
+		{"Numeric ID", "/api/v1/snippets/1", nil, http.StatusNotFound, nil},
+		{"Unlisted ID", "/api/v1/snippets/3", basicAuth("1207793251@qq.com"), http.StatusNotFound, nil},
+		{"Unlisted", "/api/v1/snippets/u3Nq8vTzYc1e", nil, http.StatusOK, []byte(`"visibility":"unlisted"`)},
+		{"Private", "/api/v1/snippets/p5Kd0wHsJa4f", nil, http.StatusNotFound, nil},
+		{"Private other user", "/api/v1/snippets/p5Kd0wHsJa4f", basicAuth("alice@example.com"), http.StatusNotFound, nil},
+		{"Private owner", "/api/v1/snippets/p5Kd0wHsJa4f", basicAuth("1207793251@qq.com"), http.StatusOK, []byte(`"visibility":"private"`)},
+		{"Burn after reading", "/api/v1/snippets/b9Rk3mVt6Hy2", nil, http.StatusNotFound, nil},
+		{"Burn after reading anonymized", "/api/v1/snippets/a4Jt6nBw2Qx9", nil, http.StatusNotFound, nil},
+		{"Burn after reading owner", "/api/v1/snippets/b9Rk3mVt6Hy2", basicAuth("1207793251@qq.com"), http.StatusOK, []byte(`"burn_after_reading":true`)},
+		{"Protected", "/api/v1/snippets/k2Pw7sLd3Nx8", nil, http.StatusForbidden, []byte(`{"error":"This snippet is password protected"}`)},
+		{"Protected anonymized", "/api/v1/snippets/q6Lf1xZr8Tb3", nil, http.StatusForbidden, []byte(`{"error":"This snippet is password protected"}`)},
+		{"Protected owner", "/api/v1/snippets/k2Pw7sLd3Nx8", basicAuth("1207793251@qq.com"), http.StatusOK, []byte(`"protected":true`)},
+		{"List", "/api/v1/snippets", nil, http.StatusOK, []byte(`"tags":["haiku"]`)},
+		{"List with limit", "/api/v1/snippets?limit=5", nil, http.StatusOK, []byte(`"next":""`)},
+		{"Invalid limit", "/api/v1/snippets?limit=1000", nil, http.StatusBadRequest, nil},
+		{"Invalid cursor", "/api/v1/snippets?after=foo", nil, http.StatusBadRequest, []byte(`{"error":"Invalid cursor"}`)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.apiRequest(t, http.MethodGet, tt.urlPath, tt.header, "")
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if ct := header.Get("Content-Type"); ct != "application/json; charset=utf-8" {
+				t.Errorf("want JSON content type; got %q", ct)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestAPICreateSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name         string
+		email        string
+		body         string
+		wantCode     int
+		wantLocation string
+		wantErrors   map[string][]string
+	}{
+		{"Valid", "1207793251@qq.com", `{"title":"O snail","content":"Climb Mount Fuji","tags":["haiku"],"expires":7}`, http.StatusCreated, "/api/v1/snippets/n2Wb6yRfEo7s", nil},
+		{"Anonymous", "", `{"title":"O snail","content":"Climb Mount Fuji","expires":7}`, http.StatusUnauthorized, "", nil},
+		{"Invalid credentials", "nobody@example.com", `{"title":"O snail","content":"Climb Mount Fuji","expires":7}`, http.StatusUnauthorized, "", nil},
+		{"Password with two-factor enabled", "carol@example.com", `{"title":"O snail","content":"Climb Mount Fuji","expires":7}`, http.StatusUnauthorized, "", nil},
+		{"Malformed JSON", "1207793251@qq.com", `{"title":`, http.StatusBadRequest, "", nil},
+		{"Unknown field", "1207793251@qq.com", `{"title":"O snail","colour":"red"}`, http.StatusBadRequest, "", nil},
+		{"Missing fields", "1207793251@qq.com", `{"title":"O snail"}`, http.StatusUnprocessableEntity, "", map[string][]string{
+			"content": {"This field cannot be blank"},
+			"expires": {"This field cannot be blank"},
+		}},
+		{"Invalid expires", "1207793251@qq.com", `{"title":"O snail","content":"Climb Mount Fuji","expires":30}`, http.StatusUnprocessableEntity, "", map[string][]string{
+			"expires": {"This field is invalid"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, body := ts.apiRequest(t, http.MethodPost, "/api/v1/snippets", basicAuth(tt.email), tt.body)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+			if code == http.StatusUnauthorized && header.Get("WWW-Authenticate") == "" {
+				t.Error("want WWW-Authenticate header")
+			}
+
+			if tt.wantErrors == nil {
+				return
+			}
+			var rs struct {
+				Errors map[string][]string `json:"errors"`
+			}
+			if err := json.Unmarshal(body, &rs); err != nil {
+				t.Fatal(err)
+			}
+			if len(rs.Errors) != len(tt.wantErrors) {
+				t.Errorf("want errors %v; got %v", tt.wantErrors, rs.Errors)
+			}
+			for field, want := range tt.wantErrors {
+				if got := rs.Errors[field]; len(got) != len(want) || got[0] != want[0] {
+					t.Errorf("want %s errors %v; got %v", field, want, got)
+				}
+			}
+		})
+	}
+}
+
+func TestAPIUpdateDeleteSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		email    string
+		body     string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Update", http.MethodPut, "/api/v1/snippets/r7Gx2kQpLm9w", "1207793251@qq.com", `{"title":"New title","content":"New content","format":"markdown"}`, http.StatusOK, []byte(`"format":"markdown"`)},
+		{"Update keeps format", http.MethodPut, "/api/v1/snippets/e8Vc4jQm1Zs5", "1207793251@qq.com", `{"title":"New title","content":"v1.PB8wP2KwTscx0-Yc.KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA"}`, http.StatusOK, []byte(`"format":"encrypted"`)},
+		{"Update keeps format validation", http.MethodPut, "/api/v1/snippets/e8Vc4jQm1Zs5", "1207793251@qq.com", `{"title":"New title","content":"New content"}`, http.StatusUnprocessableEntity, []byte(`"content":["Invalid ciphertext"]`)},
+		{"Update to encrypted", http.MethodPut, "/api/v1/snippets/r7Gx2kQpLm9w", "1207793251@qq.com", `{"title":"New title","content":"v1.PB8wP2KwTscx0-Yc.KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA","format":"encrypted"}`, http.StatusUnprocessableEntity, []byte(`"format":["Cannot convert between encrypted and unencrypted snippets"]`)},
+		{"Update from encrypted", http.MethodPut, "/api/v1/snippets/e8Vc4jQm1Zs5", "1207793251@qq.com", `{"title":"New title","content":"New content","format":"plain"}`, http.StatusUnprocessableEntity, []byte(`"format":["Cannot convert between encrypted and unencrypted snippets"]`)},
+		{"Update anonymous", http.MethodPut, "/api/v1/snippets/r7Gx2kQpLm9w", "", `{"title":"New title","content":"New content"}`, http.StatusUnauthorized, nil},
+		{"Update not owner", http.MethodPut, "/api/v1/snippets/r7Gx2kQpLm9w", "alice@example.com", `{"title":"New title","content":"New content"}`, http.StatusForbidden, nil},
+		{"Update invalid", http.MethodPut, "/api/v1/snippets/r7Gx2kQpLm9w", "1207793251@qq.com", `{"title":"","content":"New content"}`, http.StatusUnprocessableEntity, []byte(`"title":["This field cannot be blank"]`)},
+		{"Update non-existent", http.MethodPut, "/api/v1/snippets/n2Wb6yRfEo7s", "1207793251@qq.com", `{"title":"New title","content":"New content"}`, http.StatusNotFound, nil},
+		{"Delete anonymous", http.MethodDelete, "/api/v1/snippets/r7Gx2kQpLm9w", "", "", http.StatusUnauthorized, nil},
+		{"Delete not owner", http.MethodDelete, "/api/v1/snippets/r7Gx2kQpLm9w", "alice@example.com", "", http.StatusForbidden, nil},
+		{"Delete non-existent", http.MethodDelete, "/api/v1/snippets/n2Wb6yRfEo7s", "1207793251@qq.com", "", http.StatusNotFound, nil},
+		{"Delete", http.MethodDelete, "/api/v1/snippets/r7Gx2kQpLm9w", "1207793251@qq.com", "", http.StatusNoContent, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.apiRequest(t, tt.method, tt.urlPath, basicAuth(tt.email), tt.body)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q; got %q", tt.wantBody, body)
+			}
+		})
+	}
+}
+
+func TestAPITokenAuth(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	create := `{"title":"O snail","content":"Climb Mount Fuji","expires":7}`
+
+	tests := []struct {
+		name     string
+		method   string
+		urlPath  string
+		token    string
+		body     string
+		wantCode int
+	}{
+		{"Read with read token", http.MethodGet, "/api/v1/snippets/r7Gx2kQpLm9w", "sbx_readtoken", "", http.StatusOK},
+		{"Read with invalid token", http.MethodGet, "/api/v1/snippets/r7Gx2kQpLm9w", "sbx_wrong", "", http.StatusUnauthorized},
+		{"Create with read token", http.MethodPost, "/api/v1/snippets", "sbx_readtoken", create, http.StatusForbidden},
+		{"Create with write token", http.MethodPost, "/api/v1/snippets", "sbx_writetoken", create, http.StatusCreated},
+		{"Delete with read token", http.MethodDelete, "/api/v1/snippets/r7Gx2kQpLm9w", "sbx_readtoken", "", http.StatusForbidden},
+		{"Delete with write token", http.MethodDelete, "/api/v1/snippets/r7Gx2kQpLm9w", "sbx_writetoken", "", http.StatusNoContent},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, _ := ts.apiRequest(t, tt.method, tt.urlPath, bearerAuth(tt.token), tt.body)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestAPIBasicAuthThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	header := http.Header{}
+	req := &http.Request{Header: header}
+	req.SetBasicAuth("1207793251@qq.com", "wrongPa$$word")
+
+	for i := 0; i < emailPolicy.Free; i++ {
+		code, _, _ := ts.apiRequest(t, http.MethodGet, "/api/v1/snippets/r7Gx2kQpLm9w", header, "")
+		if code != http.StatusUnauthorized {
+			t.Fatalf("attempt %d: want %d; got %d", i+1, http.StatusUnauthorized, code)
+		}
+	}
+
+	code, rsHeader, _ := ts.apiRequest(t, http.MethodGet, "/api/v1/snippets/r7Gx2kQpLm9w", basicAuth("1207793251@qq.com"), "")
+	if code != http.StatusTooManyRequests {
+		t.Errorf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if rsHeader.Get("Retry-After") == "" {
+		t.Error("want Retry-After header")
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"archive/zip"
+	"encoding/json"
+	"fmt"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"io"
+	"net/http"
+	"time"
+)
+
+// exportProfile 是导出的profile.json的内容
+type exportProfile struct {
+	ID            int       `json:"id"`
+	Name          string    `json:"name"`
+	Email         string    `json:"email"`
+	EmailVerified bool      `json:"email_verified"`
+	Created       time.Time `json:"created"`
+	Exported      time.Time `json:"exported"`
+}
+
+// exportSnippet 是导出的snippets.json中每个snippet的元数据，File是压缩包中内容文件的路径
+type exportSnippet struct {
+	ID               int       `json:"id"`
+	Title            string    `json:"title"`
+	Format           string    `json:"format"`
+	Language         string    `json:"language"`
+	Visibility       string    `json:"visibility"`
+	BurnAfterReading bool      `json:"burn_after_reading"`
+	Protected        bool      `json:"protected"`
+	Tags             []string  `json:"tags"`
+	Created          time.Time `json:"created"`
+	Expires          time.Time `json:"expires"`
+	File             string    `json:"file"`
+}
+
+// exportWriteTimeout 是导出时写入每个文件的期限
+// 服务器的WriteTimeout限制的是整个响应，snippet很多时压缩包会在中途被截断，所以导出时每写一个文件就延长一次
+const exportWriteTimeout = 10 * time.Second
+
+// exportWriter 把用户的个人信息和snippet逐个写成ZIP压缩包，压缩包不需要整个放在内存中
+type exportWriter struct {
+	zw    *zip.Writer
+	flush func()
+	index []exportSnippet
+}
+
+// newExportWriter 开始写压缩包并写入profile.json，每写完一个文件就调用flush
+func newExportWriter(w io.Writer, flush func(), user *models.User) (*exportWriter, error) {
+	ew := &exportWriter{zw: zip.NewWriter(w), flush: flush, index: []exportSnippet{}}
+	err := ew.writeJSON("profile.json", exportProfile{
+		ID:            user.ID,
+		Name:          user.Name,
+		Email:         user.Email,
+		EmailVerified: user.EmailVerified,
+		Created:       user.Created,
+		Exported:      time.Now().UTC(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	return ew, nil
+}
+
+func (ew *exportWriter) writeJSON(name string, v interface{}) error {
+	f, err := ew.zw.Create(name)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(f)
+	enc.SetIndent("", "  ")
+	return enc.Encode(v)
+}
+
+// add 写入一个snippet的内容文件，元数据在close时统一写入snippets.json
+func (ew *exportWriter) add(s *models.Snippet) error {
+	// 文件名加上id前缀，标题相同的snippet不会互相覆盖
+	name := fmt.Sprintf("snippets/%d-%s", s.ID, snippetFilename(s))
+	f, err := ew.zw.CreateHeader(&zip.FileHeader{Name: name, Method: zip.Deflate, Modified: s.Created})
+	if err != nil {
+		return err
+	}
+	if _, err = io.WriteString(f, s.Content); err != nil {
+		return err
+	}
+	ew.flush()
+
+	ew.index = append(ew.index, exportSnippet{
+		ID:               s.ID,
+		Title:            s.Title,
+		Format:           s.Format,
+		Language:         s.Language,
+		Visibility:       s.Visibility,
+		BurnAfterReading: s.BurnAfterReading,
+		Protected:        s.Protected,
+		Tags:             s.Tags,
+		Created:          s.Created,
+		Expires:          s.Expires,
+		File:             name,
+	})
+	return nil
+}
+
+// close 写入snippets.json并结束压缩包
+func (ew *exportWriter) close() error {
+	if err := ew.writeJSON("snippets.json", ew.index); err != nil {
+		return err
+	}
+	return ew.zw.Close()
+}
+
+// exportFlusher 返回写完每个文件之后调用的函数，刷新响应并把写入期限延长exportWriteTimeout
+// ResponseWriter不支持时忽略错误，这时仍然受服务器WriteTimeout的限制
+func exportFlusher(w http.ResponseWriter) func() {
+	rc := http.NewResponseController(w)
+	return func() {
+		rc.Flush()
+		rc.SetWriteDeadline(time.Now().Add(exportWriteTimeout))
+	}
+}
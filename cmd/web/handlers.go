@@ -1,11 +1,20 @@
 package main
 
 import (
+	"encoding/base64"
 	"fmt"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/diff"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/forms"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/totp"
+	"github.com/skip2/go-qrcode"
+	"html/template"
+	"mime"
 	"net/http"
+	"net/url"
 	"strconv"
+	"strings"
+	"time"
 )
 
 // 改变了handler的签名为app，所以它成为了app的一个方法
@@ -16,230 +25,1690 @@ func (app *application) home(w http.ResponseWriter, r *http.Request) {
 	//}
 	// 由于Pat的特性不再需要这个判断
 
-	s, err := app.snippets.Latest()
+	// 首页按照创建时间分页展示，通过after和before查询参数翻页
+	cursor, err := pageCursor(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.snippets.List(cursor, app.pageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 首页同时展示最常用的标签
+	tags, err := app.snippets.TagCloud(30)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
 	// Create an instance of a templateData struct holding the slice of snippets
-	data := &templateData{Snippets: s}
+	data := &templateData{Snippets: page.Snippets, Page: page, Tags: tags}
 
 	// 使用helper中的render
 	app.render(w, r, "home.page.tmpl", data)
 }
 
+// 浏览所有未过期的snippet，可以通过limit查询参数选择每页的数量
+func (app *application) browseSnippets(w http.ResponseWriter, r *http.Request) {
+	cursor, err := pageCursor(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 只允许几个固定的每页数量，其它值使用默认值
+	limit := app.pageSize
+	switch r.URL.Query().Get("limit") {
+	case "10":
+		limit = 10
+	case "20":
+		limit = 20
+	case "50":
+		limit = 50
+	}
+
+	page, err := app.snippets.List(cursor, limit)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "browse.page.tmpl", &templateData{
+		Snippets: page.Snippets,
+		Page:     page,
+		Limit:    limit,
+	})
+}
+
+// 按标签分页展示snippet
+func (app *application) showTag(w http.ResponseWriter, r *http.Request) {
+	tag := r.URL.Query().Get(":name")
+	if !forms.TagRX.MatchString(tag) {
+		app.notFound(w)
+		return
+	}
+
+	cursor, err := pageCursor(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.snippets.ListByTag(tag, cursor, app.pageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "tag.page.tmpl", &templateData{
+		Tag:      tag,
+		Snippets: page.Snippets,
+		Page:     page,
+	})
+}
+
+// 用户的个人主页，展示用户的名字、注册时间以及未过期的snippet，不展示邮箱
+func (app *application) showUser(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
+		return
+	}
+
+	user, err := app.users.Get(id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	cursor, err := pageCursor(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.snippets.ListByUser(id, cursor, app.pageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	count, err := app.snippets.CountByUser(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "profile.page.tmpl", &templateData{
+		Profile:      user,
+		SnippetCount: count,
+		Snippets:     page.Snippets,
+		Page:         page,
+	})
+}
+
+// 当前用户自己的snippet列表，已经过期的snippet也会列出来
+func (app *application) userSnippets(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUser(r).ID
+
+	cursor, err := pageCursor(r)
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	page, err := app.snippets.ListOwned(id, cursor, app.pageSize)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 数量与下面的列表一致，包括已经过期的和不公开、私密等不出现在个人主页上的snippet
+	count, err := app.snippets.CountOwned(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "dashboard.page.tmpl", &templateData{
+		SnippetCount: count,
+		Snippets:     page.Snippets,
+		Page:         page,
+	})
+}
+
+// maxSearchPage 是搜索结果最多可以翻到的页数，同时防止计算偏移量时溢出
+const maxSearchPage = 100
+
+// 在标题和内容中全文搜索snippet，通过page查询参数翻页
+func (app *application) search(w http.ResponseWriter, r *http.Request) {
+	// 搜索表单使用GET提交，所以直接检验URL中的查询参数
+	form := forms.New(r.URL.Query())
+	form.MaxLength("q", 100)
+	if !form.Valid() {
+		app.render(w, r, "search.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	query := strings.TrimSpace(form.Get("q"))
+	if query == "" {
+		app.render(w, r, "search.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	page := 1
+	if value := form.Get("page"); value != "" {
+		var err error
+		page, err = strconv.Atoi(value)
+		if err != nil || page < 1 || page > maxSearchPage {
+			app.notFound(w)
+			return
+		}
+	}
+
+	// 多取一个结果用来判断是否还有下一页
+	snippets, err := app.snippets.Search(query, (page-1)*app.pageSize, app.pageSize+1)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	more := len(snippets) > app.pageSize
+	if more {
+		snippets = snippets[:app.pageSize]
+	}
+	// 最后一页之后不再提供下一页的链接
+	more = more && page < maxSearchPage
+
+	app.render(w, r, "search.page.tmpl", &templateData{
+		Form:       form,
+		Snippets:   snippets,
+		PageNumber: page,
+		HasMore:    more,
+	})
+}
+
 func (app *application) showSnippet(w http.ResponseWriter, r *http.Request) {
+	// 根据URL中的slug获取数据，当前用户无权访问的snippet与不存在一样返回404
+	s, ok := app.snippetBySlug(w, r)
+	if !ok {
+		return
+	}
+
+	// 有密码的snippet先要求输入密码
+	if app.snippetLocked(r, s) {
+		app.render(w, r, "unlock.page.tmpl", &templateData{Snippet: s, Form: forms.New(nil)})
+		return
+	}
+
+	// 阅后即焚的snippet先展示确认页面，不展示内容，作者在这里得到分享的链接
+	if s.BurnAfterReading {
+		app.render(w, r, "burn.page.tmpl", &templateData{Snippet: s})
+		return
+	}
+
+	// Pass the flash message to the template，将这个逻辑放在了
+	// helpers中默认添加（显示flash）
+	app.render(w, r, "show.page.tmpl", &templateData{
+		Snippet: s,
+	})
+}
+
+// 确认查看阅后即焚的snippet，删除成功之后才展示内容
+// 两个人同时查看时只有一个人可以删除成功，另一个人得到404
+func (app *application) burnSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetBySlug(w, r)
+	if !ok {
+		return
+	}
+	if !s.BurnAfterReading || app.snippetLocked(r, s) {
+		http.Redirect(w, r, s.Link(), http.StatusSeeOther)
+		return
+	}
+
+	err := app.snippets.Burn(s.ID)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 内容只展示这一次，不允许浏览器缓存
+	w.Header().Set("Cache-Control", "no-store")
+	app.render(w, r, "show.page.tmpl", &templateData{
+		Snippet: s,
+		Burned:  true,
+	})
+}
+
+// 检查snippet的查看密码，正确时在session中记住，之后不需要再次输入
+// 失败次数按snippet限流，防止暴力猜测密码
+func (app *application) unlockSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetBySlug(w, r)
+	if !ok {
+		return
+	}
+	if !app.snippetLocked(r, s) {
+		http.Redirect(w, r, s.Link(), http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password")
+	if !form.Valid() {
+		app.render(w, r, "unlock.page.tmpl", &templateData{Snippet: s, Form: form})
+		return
+	}
+
+	// 先把这次尝试计入失败次数再检查密码，同时到达的猜测不能都通过限制
+	key := snippetKey(s.ID)
+	wait, err := app.snippetLimiter.Attempt(key)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if wait > 0 {
+		form.Errors.Add("generic", fmt.Sprintf("密码错误次数过多，请在%s后重试", waitMessage(wait)))
+		retryAfter(w, wait)
+		app.render(w, r, "unlock.page.tmpl", &templateData{Snippet: s, Form: form})
+		return
+	}
+
+	err = app.snippets.CheckPassword(s.ID, form.Get("password"))
+	if err == models.ErrInvalidCredentials {
+		form.Errors.Add("generic", "密码错误")
+		app.render(w, r, "unlock.page.tmpl", &templateData{Snippet: s, Form: form})
+		return
+	} else if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 密码正确时撤销这次尝试的计数，但不清除其他人猜错的记录
+	if err := app.snippetLimiter.Refund(key); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Put(r, unlockedKey(s.ID), true)
+	http.Redirect(w, r, s.Link(), http.StatusSeeOther)
+}
+
+// 把以前使用数字id的地址重定向到对应的slug地址，路径中id之后的部分和查询参数保持不变
+// 只有公开的snippet使用永久重定向，作者访问自己不公开的snippet时使用临时重定向，以免可见性改变后被缓存
+func (app *application) redirectSnippetID(w http.ResponseWriter, r *http.Request) {
 	// Pat不会从命名捕获中移除冒号
-	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	param := r.URL.Query().Get(":id")
+	id, err := strconv.Atoi(param)
 	if err != nil || id < 1 {
 		app.notFound(w)
 		return
 	}
 
-	// 使用Get方法根据指定id获取数据
-	s, err := app.snippets.Get(id)
-	if err == models.ErrNoRecord {
-		app.notFound(w)
+	// 使用Get方法根据指定id获取数据，不公开的snippet只有作者可以通过id找到
+	s, err := app.snippets.Get(id, app.viewerID(r))
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	target := s.Link() + strings.TrimPrefix(r.URL.Path, "/snippet/"+param)
+	// Pat把命名捕获加在了查询参数中，重定向时需要去掉
+	query := r.URL.Query()
+	for key := range query {
+		if strings.HasPrefix(key, ":") {
+			query.Del(key)
+		}
+	}
+	if len(query) > 0 {
+		target += "?" + query.Encode()
+	}
+
+	status := http.StatusFound
+	if s.Visibility == models.VisibilityPublic {
+		status = http.StatusMovedPermanently
+	}
+	http.Redirect(w, r, target, status)
+}
+
+// 以纯文本的形式返回snippet的内容，方便使用curl等工具直接获取
+func (app *application) rawSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	// 防止浏览器把内容当作HTML等其他类型执行
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(s.Content))
+}
+
+// 以附件的形式下载snippet，文件名由标题和语言生成
+func (app *application) downloadSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	// FormatMediaType会为非ASCII的文件名使用RFC 2231编码
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": snippetFilename(s)})
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Disposition", disposition)
+	w.Write([]byte(s.Content))
+}
+
+// 返回浏览器加密的snippet保存的密文，格式与创建时提交的相同，其它格式的snippet返回404
+func (app *application) ciphertextSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+	if s.Format != models.FormatEncrypted {
+		app.notFound(w)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Write([]byte(s.Content))
+}
+
+// 创建snippet时选择阅后即焚的expires值，一直没有被查看的阅后即焚snippet在burnExpiresDays天后过期
+const (
+	expiresBurn     = "burn"
+	burnExpiresDays = "7"
+)
+
+// Add a new createSnippetForm handler, which for now returns a placeholder result
+func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "create.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// 处理请求体中的数据
+func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
+	// 首先我们调用r.ParseForm()将来自于POST请求体的数据拿到r.PostForm map中
+	// 对PUT，PATCH请求同样有效，如果有错返回400错误
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 创建一个新的表单结构体来保存客户端上传的表单数据
+	// 再使用检验方法去检查内容是否有错
+	form := forms.New(r.PostForm)
+	form.Required("expires")
+	form.PermittedValues("expires", "365", "7", "1", expiresBurn)
+	// bcrypt只使用密码的前72个字节，多字节字符的密码按字符数计算会超过这个限制
+	form.MaxBytes("password", 72)
+	validateSnippetForm(form)
+
+	// 如果表单有错误，重新展示模版内容及其中数据
+	// 加密的内容不再回填，否则再次提交时会被加密两次，密钥也只在上一次提交的页面中
+	if !form.Valid() {
+		if form.Get("format") == models.FormatEncrypted {
+			form.Set("content", "")
+			form.Set("format", "")
+			form.Errors.Add("content", "加密的内容没有保留，请重新输入")
+		}
+		app.render(w, r, "create.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// 表单数据已经被嵌入在form.Form结构体中，直接使用Get方法获取相应属性的有效的值
+	// 路由已经被requireAuthenticatedUser保护，上下文中一定有当前用户，将其作为作者
+	s := &models.Snippet{UserID: app.authenticatedUser(r).ID, Visibility: models.VisibilityPublic}
+	fillSnippet(s, form)
+	expires := form.Get("expires")
+	if expires == expiresBurn {
+		s.BurnAfterReading = true
+		expires = burnExpiresDays
+	}
+	s.Password = form.Get("password")
+	s.ID, err = app.snippets.Insert(s, expires)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 使用Put方法去添加一个字符串值和一个响应key给session data
+	// 如果session不存在，session中间件会自动为我们创建一个新的
+	app.session.Put(r, "flash", "日志已成功创建!")
+
+	// 创建成功将用户重定向到相关的页面
+	http.Redirect(w, r, s.Link(), http.StatusSeeOther)
+}
+
+// 展示编辑snippet的表单，表单中预先填入当前的标题和内容
+func (app *application) editSnippetForm(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	app.render(w, r, "edit.page.tmpl", &templateData{
+		Snippet: s,
+		Form: forms.New(url.Values{
+			"title":      []string{s.Title},
+			"content":    []string{s.Content},
+			"tags":       []string{strings.Join(s.Tags, ", ")},
+			"format":     []string{s.Format},
+			"language":   []string{s.Language},
+			"visibility": []string{s.Visibility},
+		}),
+	})
+}
+
+// 处理编辑snippet的表单，检验规则与createSnippet相同，过期时间保持不变
+func (app *application) editSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	validateSnippetForm(form)
+	validateFormatChange(form, s)
+
+	if !form.Valid() {
+		app.render(w, r, "edit.page.tmpl", &templateData{Snippet: s, Form: form})
+		return
+	}
+
+	fillSnippet(s, form)
+
+	err = app.snippets.Update(s)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "日志已成功修改!")
+	http.Redirect(w, r, s.Link(), http.StatusSeeOther)
+}
+
+// 删除当前用户自己的snippet
+func (app *application) deleteSnippet(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	err := app.snippets.Delete(s.ID)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "日志已删除!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// 展示snippet的所有历史版本
+func (app *application) snippetHistory(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := app.snippets.Revisions(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "history.page.tmpl", &templateData{
+		Snippet:   s,
+		Revisions: revisions,
+	})
+}
+
+// 展示snippet的某一个历史版本
+func (app *application) showRevision(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	number, err := strconv.Atoi(r.URL.Query().Get(":rev"))
+	if err != nil || number < 1 {
+		app.notFound(w)
+		return
+	}
+
+	rv, err := app.snippets.Revision(s.ID, number)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "revision.page.tmpl", &templateData{
+		Snippet:  s,
+		Revision: rv,
+	})
+}
+
+// 比较snippet的两个版本，通过查询参数from和to指定版本号
+// 没有指定时默认比较最新的版本和它的上一个版本
+func (app *application) snippetDiff(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return
+	}
+
+	revisions, err := app.snippets.Revisions(s.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if len(revisions) == 0 {
+		app.notFound(w)
+		return
+	}
+
+	// 在已有的版本中查找查询参数指定的版本，没有指定时返回def
+	find := func(param string, def int) (*models.Revision, bool) {
+		number := def
+		if value := r.URL.Query().Get(param); value != "" {
+			n, err := strconv.Atoi(value)
+			if err != nil {
+				return nil, false
+			}
+			number = n
+		}
+		for _, rv := range revisions {
+			if rv.Number == number {
+				return rv, true
+			}
+		}
+		return nil, false
+	}
+
+	// 版本列表按版本号从新到旧排列，to默认为最新版本，from默认为to的上一个版本
+	to, ok := find("to", revisions[0].Number)
+	if !ok {
+		app.notFound(w)
+		return
+	}
+	from, ok := find("from", to.Number-1)
+	if !ok {
+		// 没有更早的版本时与自身比较
+		if r.URL.Query().Get("from") != "" {
+			app.notFound(w)
+			return
+		}
+		from = to
+	}
+
+	app.render(w, r, "diff.page.tmpl", &templateData{
+		Snippet:   s,
+		Revisions: revisions,
+		DiffFrom:  from,
+		DiffTo:    to,
+		Diff:      diff.Unified(from.Content, to.Content, 3),
+	})
+}
+
+// 将snippet恢复为某个历史版本，恢复本身也会保存为一个新的版本
+func (app *application) restoreRevision(w http.ResponseWriter, r *http.Request) {
+	s, ok := app.ownedSnippet(w, r)
+	if !ok {
+		return
+	}
+
+	// 版本中不记录格式，无法确认旧版本的内容也是密文，所以加密的snippet不能恢复
+	if s.Format == models.FormatEncrypted {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	number, err := strconv.Atoi(r.URL.Query().Get(":rev"))
+	if err != nil || number < 1 {
+		app.notFound(w)
+		return
+	}
+
+	rv, err := app.snippets.Revision(s.ID, number)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 版本中不记录格式、语言和标签，恢复时保留当前的设置
+	s.Title = rv.Title
+	s.Content = rv.Content
+	err = app.snippets.Update(s)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", fmt.Sprintf("已恢复到版本 %d!", rv.Number))
+	http.Redirect(w, r, s.Link(), http.StatusSeeOther)
+}
+
+// 添加关于用户登录登出等一系列方法
+// 展示用户注册表单
+func (app *application) signupUserForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "signup.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// 实现用户注册，创建一个新用户
+// 表单被提交时，数据就会发到这个处理器中
+func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
+	// Parse the form data
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// Validate the form contents using the form helper
+	// 与上面createSnippet逻辑一样
+	form := forms.New(r.PostForm)
+	form.Required("name", "email", "password")
+	form.MatchesPattern("email", forms.EmailRX)
+	form.MinLength("password", 8)
+
+	// 如果有格式发生，指出错误并重现所填（除了密码）
+	if !form.Valid() {
+		app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// 如果没有格式错误，但有可能出现邮箱重复错误，新建用户记录插入
+	id, err := app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
+	if err == models.ErrDuplicateEmail {
+		form.Errors.Add("email", "Address is already in use")
+		app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 发送验证邮件，发送失败时用户可以在登录后重新发送，所以只记录错误
+	err = app.sendVerificationEmail(&models.User{ID: id, Name: form.Get("name"), Email: form.Get("email")})
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+
+	// 添加一个临时信息到session确定登录成功并要求登录
+	app.session.Put(r, "flash", "Your signup was successful. We've sent a verification link to your email. Please log in.")
+
+	// And redirect the user to login page
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// 展示用户登录表单
+func (app *application) loginUserForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "login.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// 实现用户登录
+func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 调用Usermodel数据库中的验证方法
+	// 如果出现凭证错误，返回邮箱或密码错误提示信息
+	form := forms.New(r.PostForm)
+	email, ip := form.Get("email"), clientIP(r)
+
+	// 失败次数过多时直接拒绝，不再进行代价很高的bcrypt比较
+	wait, err := app.loginAttempt(email, ip)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if wait > 0 {
+		app.auditLogin(email, ip, loginLocked)
+		app.tooManyAttempts(w, r, "login.page.tmpl", form, wait)
+		return
+	}
+
+	id, err := app.users.Authenticate(email, form.Get("password"))
+	if err == models.ErrInvalidCredentials {
+		app.auditLogin(email, ip, loginFailure)
+		form.Errors.Add("generic", "邮箱或密码出现错误")
+		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 开启了两步验证的用户还需要输入验证码，这时只记录通过了密码验证的用户
+	_, enabled, err := app.twoFactor.Secret(id)
+	if err != nil && err != models.ErrNoRecord {
+		app.serverError(w, err)
+		return
+	}
+	if enabled {
+		if err := app.loginPassed(email, ip); err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "pendingUserID", id)
+		app.session.Put(r, "pendingExpires", int(time.Now().Add(pendingLoginTTL).Unix()))
+		http.Redirect(w, r, "/user/login/2fa", http.StatusSeeOther)
+		return
+	}
+
+	if err := app.loginSucceeded(email, ip); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.auditLogin(email, ip, loginSuccess)
+
+	// 将id加入到当前用户的session中
+	if err := app.logIn(r, id); err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 重定向到创建日志页面
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// pendingLoginTTL 是输入密码之后完成两步验证的时限
+const pendingLoginTTL = 5 * time.Minute
+
+// pendingUser 返回已经通过密码验证、正在等待两步验证的用户id
+// 过期时间以Unix时间戳保存在session中
+func (app *application) pendingUser(r *http.Request) (int, bool) {
+	id := app.session.GetInt(r, "pendingUserID")
+	expires := int64(app.session.GetInt(r, "pendingExpires"))
+	if id == 0 || time.Now().Unix() > expires {
+		return 0, false
+	}
+	return id, true
+}
+
+// 登录的第二步，输入身份验证器中的验证码或者恢复码
+func (app *application) loginTwoFactorForm(w http.ResponseWriter, r *http.Request) {
+	if _, ok := app.pendingUser(r); !ok {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	app.render(w, r, "login2fa.page.tmpl", &templateData{Form: forms.New(nil)})
+}
+
+func (app *application) loginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id, ok := app.pendingUser(r)
+	if !ok {
+		app.session.Put(r, "flash", "登录已超时，请重新输入密码")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// 验证码只有6位数字，同样需要限制失败次数，失败次数与密码错误一起计算
+	user, err := app.users.Get(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	ip := clientIP(r)
+	wait, err := app.loginAttempt(user.Email, ip)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	if wait > 0 {
+		app.auditLogin(user.Email, ip, loginLocked)
+		app.tooManyAttempts(w, r, "login2fa.page.tmpl", form, wait)
+		return
+	}
+
+	secret, _, err := app.twoFactor.Secret(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	recovery, err := app.checkTwoFactorCode(id, secret, form.Get("code"))
+	if err == models.ErrInvalidToken {
+		app.auditLogin(user.Email, ip, loginTwoFactorFails)
+		form.Errors.Add("code", "验证码错误")
+		app.render(w, r, "login2fa.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	if err := app.loginSucceeded(user.Email, ip); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.auditLogin(user.Email, ip, loginSuccess)
+
+	if recovery {
+		left, err := app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.session.Put(r, "flash", fmt.Sprintf("你使用了一个恢复码，还剩%d个恢复码", left))
+	}
+
+	app.session.Remove(r, "pendingUserID")
+	app.session.Remove(r, "pendingExpires")
+	if err := app.logIn(r, id); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+}
+
+// checkTwoFactorCode 检查TOTP验证码或者恢复码，两者都只能使用一次
+// 返回是否使用了恢复码，验证码无效时返回ErrInvalidToken
+func (app *application) checkTwoFactorCode(userID int, secret, code string) (bool, error) {
+	if step, ok := totp.Validate(secret, code, time.Now()); ok {
+		return false, app.twoFactor.UseStep(userID, step)
+	}
+	err := app.twoFactor.UseRecoveryCode(userID, code)
+	return err == nil, err
+}
+
+// logIn 换一个新的会话令牌之后记录登录的用户，防止会话固定攻击
+func (app *application) logIn(r *http.Request, id int) error {
+	if err := app.session.RenewToken(r); err != nil {
+		return err
+	}
+	app.session.Put(r, "userID", id)
+	return nil
+}
+
+// 实现用户登出
+func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
+	// 删除服务端的会话记录并换一个新的令牌，旧的令牌不能再使用
+	// 清空会话数据来实现登出，包括userID和已经输入过密码的snippet
+	err := app.session.RenewToken(r)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.session.Clear(r)
+	// Add a flash message to the session to confirm 已经登出
+	app.session.Put(r, "flash", "You've been logged out successfully!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// 实现展示app介绍页面，只涉及静态信息，不涉及用户输入
+func (app *application) about(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "about.page.tmpl", &templateData{})
+}
+
+// 添加展示重置密码的页面的处理函数
+func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "resetpassword.page.tmpl", &templateData{
+		Form: forms.New(nil),
+	})
+}
+
+// 添加处理提交的重置密码的处理函数
+func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	// 检验表单中传来的密码,利用到了form.go和helpers.go
+	// 需要输入当前密码，防止别人使用已经登录的浏览器修改密码
+	form := forms.New(r.PostForm)
+	form.Required("current_password", "new_password", "confirm_password")
+	form.MinLength("new_password", 8)
+	form.Matches("new_password", "confirm_password")
+	form.Differs("current_password", "new_password")
+
+	// 如果上面的几个检验出现错误
+	if !form.Valid() {
+		app.render(w, r, "resetpassword.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	// 如果没有格式错误，直接获取当前用户ID，仅对已经登录的用户
+	userID, ok := app.session.Get(r, "userID").(int)
+
+	if !ok {
+		app.clientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	err = app.users.CheckPassword(userID, form.Get("current_password"))
+	if err == models.ErrInvalidCredentials {
+		form.Errors.Add("current_password", "当前密码错误")
+		app.render(w, r, "resetpassword.page.tmpl", &templateData{Form: form})
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.UpdatePassword(userID, form.Get("new_password"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 修改密码之后，其它设备上的会话全部失效
+	err = app.session.Store.DeleteUser(userID, app.session.ID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.recordActivity(r, userID, activityPasswordChanged)
+	app.session.Put(r, "flash", "密码修改成功！")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// 展示当前用户邮箱的验证状态，还没有验证时可以重新发送验证邮件
+func (app *application) verifyEmailPage(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "verify.page.tmpl", &templateData{})
+}
+
+// 重新发送验证邮件
+func (app *application) resendVerification(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	if user.EmailVerified {
+		app.session.Put(r, "flash", "你的邮箱已经验证过了")
+		http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+		return
+	}
+
+	err := app.sendVerificationEmail(user)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "验证邮件已经重新发送，请查收")
+	http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+}
+
+// 通过邮件中的签名链接验证邮箱
+func (app *application) verifyEmail(w http.ResponseWriter, r *http.Request) {
+	user, err := app.checkVerificationToken(r.URL.Query().Get(":token"))
+	if err == models.ErrInvalidToken {
+		app.session.Put(r, "flash", "验证链接无效或者已经过期，请重新发送验证邮件")
+		http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	err = app.users.VerifyEmail(user.ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "邮箱验证成功！")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// 账号设置页面，可以修改显示名称和邮箱
+func (app *application) settingsForm(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+	app.render(w, r, "settings.page.tmpl", &templateData{
+		Form: forms.New(url.Values{"name": {user.Name}, "email": {user.Email}}),
+	})
+}
+
+// 保存账号设置，名称立即修改，新邮箱需要通过发送到新邮箱的链接确认之后才会修改
+func (app *application) updateSettings(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("name", "email")
+	form.MaxLength("name", 255)
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
+
+	if !form.Valid() {
+		app.render(w, r, "settings.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	user := app.authenticatedUser(r)
+	name, email := form.Get("name"), form.Get("email")
+	emailChanged := !strings.EqualFold(email, user.Email)
+
+	// 先检查新邮箱有没有被使用，避免只修改了一半的设置
+	if emailChanged {
+		other, err := app.users.GetByEmail(email)
+		if err == nil && other.ID != user.ID {
+			form.Errors.Add("email", "Address is already in use")
+			app.render(w, r, "settings.page.tmpl", &templateData{Form: form})
+			return
+		} else if err != nil && err != models.ErrNoRecord {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	flash := "设置已保存"
+	if name != user.Name {
+		err = app.users.UpdateName(user.ID, name)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.recordActivity(r, user.ID, activityNameChanged)
+	}
+
+	if emailChanged {
+		err = app.sendEmailChangeEmails(user, email)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		flash = "确认链接已经发送到新邮箱，打开链接之后邮箱才会修改"
+	}
+
+	app.session.Put(r, "flash", flash)
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// 通过发送到新邮箱的链接确认修改邮箱，链接本身不需要登录
+func (app *application) confirmEmailChange(w http.ResponseWriter, r *http.Request) {
+	user, email, err := app.checkEmailChangeToken(r.URL.Query().Get(":token"))
+	if err == models.ErrInvalidToken {
+		app.session.Put(r, "flash", "确认链接无效或者已经过期，请重新修改邮箱")
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 发送确认链接之后新邮箱可能已经被其它用户注册
+	err = app.users.UpdateEmail(user.ID, email)
+	if err == models.ErrDuplicateEmail {
+		app.session.Put(r, "flash", "这个邮箱已经被其它账号使用")
+		http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.recordActivity(r, user.ID, activityEmailChanged)
+
+	app.session.Put(r, "flash", "邮箱修改成功！")
+	http.Redirect(w, r, "/user/settings", http.StatusSeeOther)
+}
+
+// 导出当前用户的个人数据，ZIP压缩包中包括profile.json和所有snippet的内容文件
+// snippet一边从数据库中读取一边写入响应，导出很多snippet时也不需要全部放在内存中
+func (app *application) exportAccount(w http.ResponseWriter, r *http.Request) {
+	user := app.authenticatedUser(r)
+
+	filename := fmt.Sprintf("snippetbox-export-%s.zip", time.Now().UTC().Format("20060102"))
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", mime.FormatMediaType("attachment", map[string]string{"filename": filename}))
+	w.Header().Set("Cache-Control", "no-store")
+
+	// 响应已经开始发送，出错时只能记录日志，客户端会收到一个不完整的压缩包
+	flush := exportFlusher(w)
+	flush()
+	ew, err := newExportWriter(w, flush, user)
+	if err == nil {
+		err = app.snippets.Export(user.ID, ew.add)
+	}
+	if err == nil {
+		err = ew.close()
+	}
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// 展示注销账号的确认页面
+func (app *application) deleteAccountForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "delete.page.tmpl", &templateData{
+		Form: forms.New(url.Values{"snippets": {"anonymize"}}),
+	})
+}
+
+// 注销当前用户，需要输入密码确认，并选择删除还是匿名保留自己的snippet
+func (app *application) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "snippets")
+	form.PermittedValues("snippets", "delete", "anonymize")
+	if !form.Valid() {
+		app.render(w, r, "delete.page.tmpl", &templateData{Form: form})
+		return
+	}
+
+	id := app.authenticatedUser(r).ID
+	err = app.users.CheckPassword(id, form.Get("password"))
+	if err == models.ErrInvalidCredentials {
+		form.Errors.Add("password", "密码错误")
+		app.render(w, r, "delete.page.tmpl", &templateData{Form: form})
 		return
 	} else if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// Pass the flash message to the template，将这个逻辑放在了
-	// helpers中默认添加（显示flash）
-	app.render(w, r, "show.page.tmpl", &templateData{
-		Snippet: s,
-	})
+	err = app.users.Delete(id, form.Get("snippets") == "anonymize")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 当前会话换成一个未登录的新会话，再删除这个用户其它所有的会话
+	app.session.Remove(r, "userID")
+	if err := app.session.RenewToken(r); err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.session.Store.DeleteUser(id, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "你的账号已经注销")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
 }
 
-// Add a new createSnippetForm handler, which for now returns a placeholder result
-func (app *application) createSnippetForm(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "create.page.tmpl", &templateData{
+// resetPasswordTTL 是找回密码邮件中链接的有效期
+const resetPasswordTTL = time.Hour
+
+// 展示忘记密码的表单
+func (app *application) forgotPasswordForm(w http.ResponseWriter, r *http.Request) {
+	app.render(w, r, "forgotpassword.page.tmpl", &templateData{
 		Form: forms.New(nil),
 	})
 }
 
-// 处理请求体中的数据
-func (app *application) createSnippet(w http.ResponseWriter, r *http.Request) {
-	// 首先我们调用r.ParseForm()将来自于POST请求体的数据拿到r.PostForm map中
-	// 对PUT，PATCH请求同样有效，如果有错返回400错误
+// 如果邮箱已经注册，生成一次性的重置令牌并通过邮件发送重置链接
+// 无论邮箱是否存在都返回相同的提示，防止通过这个页面探测哪些邮箱已经注册
+func (app *application) forgotPassword(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// 创建一个新的表单结构体来保存客户端上传的表单数据
-	// 再使用检验方法去检查内容是否有错
 	form := forms.New(r.PostForm)
-	form.Required("title", "content", "expires")
-	form.MaxLength("title", 100)
-	form.PermittedValues("expires", "365", "7", "1")
+	form.Required("email")
+	form.MaxLength("email", 255)
+	form.MatchesPattern("email", forms.EmailRX)
 
-	// 如果表单有错误，重新展示模版内容及其中数据
 	if !form.Valid() {
-		app.render(w, r, "create.page.tmpl", &templateData{Form: form})
+		app.render(w, r, "forgotpassword.page.tmpl", &templateData{Form: form})
 		return
 	}
 
-	// 表单数据已经被嵌入在form.Form结构体中，直接使用Get方法获取相应属性的有效的值
-	id, err := app.snippets.Insert(form.Get("title"), form.Get("content"), form.Get("expires"))
-	if err != nil {
+	user, err := app.users.GetByEmail(form.Get("email"))
+	if err != nil && err != models.ErrNoRecord {
 		app.serverError(w, err)
 		return
 	}
 
-	// 使用Put方法去添加一个字符串值和一个响应key给session data
-	// 如果session不存在，session中间件会自动为我们创建一个新的
-	app.session.Put(r, "flash", "日志已成功创建!")
+	if user != nil {
+		token, err := app.resets.Insert(user.ID, resetPasswordTTL)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
 
-	// 创建成功将用户重定向到相关的页面
-	http.Redirect(w, r, fmt.Sprintf("/snippet/%d", id), http.StatusSeeOther)
+		body := fmt.Sprintf("%s，你好：\n\n请在%d分钟内打开下面的链接重置你的Snippetbox密码：\n\n%s/user/resetpassword/%s\n\n如果你没有申请重置密码，请忽略这封邮件。\n",
+			user.Name, int(resetPasswordTTL.Minutes()), app.baseURL, token)
+		err = app.mailer.Send(user.Email, "重置Snippetbox密码", body)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+
+	app.session.Put(r, "flash", "如果该邮箱已经注册，重置密码的链接已经发送到你的邮箱")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-// 添加关于用户登录登出等一系列方法
-// 展示用户注册表单
-func (app *application) signupUserForm(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "signup.page.tmpl", &templateData{
-		Form: forms.New(nil),
+// 通过邮件中的链接打开重置密码的表单，令牌无效时返回登录页面
+func (app *application) recoverPasswordForm(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get(":token")
+	_, err := app.resets.Check(token)
+	if err == models.ErrInvalidToken {
+		app.invalidResetToken(w, r)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 页面引用了外部的字体文件，不能让令牌通过Referer头泄露出去
+	w.Header().Set("Referrer-Policy", "no-referrer")
+	app.render(w, r, "resetpassword.page.tmpl", &templateData{
+		Form:       forms.New(nil),
+		ResetToken: token,
 	})
 }
 
-// 实现用户注册，创建一个新用户
-// 表单被提交时，数据就会发到这个处理器中
-func (app *application) signupUser(w http.ResponseWriter, r *http.Request) {
-	// Parse the form data
+// 使用邮件中的令牌设置新密码，令牌只能使用一次
+func (app *application) recoverPassword(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// Validate the form contents using the form helper
-	// 与上面createSnippet逻辑一样
+	token := r.URL.Query().Get(":token")
 	form := forms.New(r.PostForm)
-	form.Required("name", "email", "password")
-	form.MatchesPattern("email", forms.EmailRX)
-	form.MinLength("password", 8)
+	form.Required("new_password", "confirm_password")
+	form.MinLength("new_password", 8)
+	form.Matches("new_password", "confirm_password")
 
-	// 如果有格式发生，指出错误并重现所填（除了密码）
 	if !form.Valid() {
-		app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
+		w.Header().Set("Referrer-Policy", "no-referrer")
+		app.render(w, r, "resetpassword.page.tmpl", &templateData{Form: form, ResetToken: token})
 		return
 	}
 
-	// 如果没有格式错误，但有可能出现邮箱重复错误，新建用户记录插入
-	err = app.users.Insert(form.Get("name"), form.Get("email"), form.Get("password"))
-	if err == models.ErrDuplicateEmail {
-		form.Errors.Add("email", "Address is already in use")
-		app.render(w, r, "signup.page.tmpl", &templateData{Form: form})
+	userID, err := app.resets.Consume(token)
+	if err == models.ErrInvalidToken {
+		app.invalidResetToken(w, r)
 		return
 	} else if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// 添加一个临时信息到session确定登录成功并要求登录
-	app.session.Put(r, "flash", "Your signup was successful. Please log in.")
+	err = app.users.UpdatePassword(userID, form.Get("new_password"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-	// And redirect the user to login page
+	// 通过邮件重置密码时当前没有登录，用户所有的会话都要失效
+	err = app.session.Store.DeleteUser(userID, "")
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	app.recordActivity(r, userID, activityPasswordReset)
+
+	app.session.Put(r, "flash", "密码修改成功！")
 	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 }
 
-// 展示用户登录表单
-func (app *application) loginUserForm(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "login.page.tmpl", &templateData{
-		Form: forms.New(nil),
+func (app *application) invalidResetToken(w http.ResponseWriter, r *http.Request) {
+	app.session.Put(r, "flash", "重置密码的链接无效或者已经过期，请重新申请")
+	http.Redirect(w, r, "/user/forgotpassword", http.StatusSeeOther)
+}
+
+// 展示两步验证的状态，刚开启两步验证时展示恢复码
+func (app *application) twoFactorPage(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUser(r).ID
+	_, enabled, err := app.twoFactor.Secret(id)
+	if err != nil && err != models.ErrNoRecord {
+		app.serverError(w, err)
+		return
+	}
+
+	data := &templateData{Form: forms.New(nil), TwoFactorEnabled: enabled}
+	if enabled {
+		data.RecoveryCodesLeft, err = app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if codes := app.session.PopString(r, "recoveryCodes"); codes != "" {
+		data.RecoveryCodes = strings.Split(codes, "\n")
+	}
+
+	app.render(w, r, "twofactor.page.tmpl", data)
+}
+
+// 开始设置两步验证，生成新的密钥，在确认验证码之前两步验证不会开启
+func (app *application) beginTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := app.authenticatedUser(r).ID
+	_, enabled, err := app.twoFactor.Secret(id)
+	if err != nil && err != models.ErrNoRecord {
+		app.serverError(w, err)
+		return
+	}
+	if enabled {
+		app.session.Put(r, "flash", "两步验证已经开启")
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+	err = app.twoFactor.Begin(id, secret)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	http.Redirect(w, r, "/user/2fa/setup", http.StatusSeeOther)
+}
+
+// 展示密钥的二维码以及确认验证码的表单
+func (app *application) twoFactorSetupPage(w http.ResponseWriter, r *http.Request) {
+	app.renderTwoFactorSetup(w, r, forms.New(nil))
+}
+
+func (app *application) renderTwoFactorSetup(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	user := app.authenticatedUser(r)
+	secret, enabled, err := app.twoFactor.Secret(user.ID)
+	if err == models.ErrNoRecord || enabled {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	png, err := qrcode.Encode(totp.URL("Snippetbox", user.Email, secret), qrcode.Medium, 256)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "twofactorsetup.page.tmpl", &templateData{
+		Form:       form,
+		TOTPSecret: secret,
+		QRCode:     template.URL("data:image/png;base64," + base64.StdEncoding.EncodeToString(png)),
 	})
 }
 
-// 实现用户登录
-func (app *application) loginUser(w http.ResponseWriter, r *http.Request) {
+// 确认身份验证器中的验证码，正确时开启两步验证并生成恢复码
+func (app *application) enableTwoFactor(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// 调用Usermodel数据库中的验证方法
-	// 如果出现凭证错误，返回邮箱或密码错误提示信息
-	form := forms.New(r.PostForm)
-	id, err := app.users.Authenticate(form.Get("email"), form.Get("password"))
-	if err == models.ErrInvalidCredentials {
-		form.Errors.Add("generic", "邮箱或密码出现错误")
-		app.render(w, r, "login.page.tmpl", &templateData{Form: form})
+	id := app.authenticatedUser(r).ID
+	secret, enabled, err := app.twoFactor.Secret(id)
+	if err == models.ErrNoRecord || enabled {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
 		return
 	} else if err != nil {
 		app.serverError(w, err)
 		return
 	}
 
-	// 将id加入到当前用户的session中
-	app.session.Put(r, "userID", id)
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	step, ok := totp.Validate(secret, form.Get("code"), time.Now())
+	if form.Valid() && !ok {
+		form.Errors.Add("code", "验证码错误，请检查手机的时间是否准确")
+	}
+	if !form.Valid() {
+		app.renderTwoFactorSetup(w, r, form)
+		return
+	}
 
-	// 重定向到创建日志页面
-	http.Redirect(w, r, "/snippet/create", http.StatusSeeOther)
+	codes, err := app.twoFactor.Enable(id, step)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "recoveryCodes", strings.Join(codes, "\n"))
+	app.session.Put(r, "flash", "两步验证已开启")
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
 }
 
-// 实现用户登出
-func (app *application) logoutUser(w http.ResponseWriter, r *http.Request) {
-	// Remove the userID from the session data 来实现登出
-	app.session.Remove(r, "userID")
-	// Add a flash message to the session to confirm 已经登出
-	app.session.Put(r, "flash", "You've been logged out successfully!")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
+// 关闭两步验证，需要输入验证码或者恢复码
+func (app *application) disableTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		app.clientError(w, http.StatusBadRequest)
+		return
+	}
+
+	id := app.authenticatedUser(r).ID
+	secret, enabled, err := app.twoFactor.Secret(id)
+	if err == models.ErrNoRecord || !enabled {
+		http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if form.Valid() {
+		_, err = app.checkTwoFactorCode(id, secret, form.Get("code"))
+		if err == models.ErrInvalidToken {
+			form.Errors.Add("code", "验证码错误")
+		} else if err != nil {
+			app.serverError(w, err)
+			return
+		}
+	}
+	if !form.Valid() {
+		left, err := app.twoFactor.RecoveryCodesLeft(id)
+		if err != nil {
+			app.serverError(w, err)
+			return
+		}
+		app.render(w, r, "twofactor.page.tmpl", &templateData{Form: form, TwoFactorEnabled: true, RecoveryCodesLeft: left})
+		return
+	}
+
+	err = app.twoFactor.Disable(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "两步验证已关闭")
+	http.Redirect(w, r, "/user/2fa", http.StatusSeeOther)
 }
 
-// 实现展示app介绍页面，只涉及静态信息，不涉及用户输入
-func (app *application) about(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "about.page.tmpl", &templateData{})
+// 展示当前用户的API令牌以及创建令牌的表单
+func (app *application) tokensPage(w http.ResponseWriter, r *http.Request) {
+	app.renderTokens(w, r, forms.New(url.Values{"scope": {models.ScopeRead}}))
 }
 
-// 添加展示重置密码的页面的处理函数
-func (app *application) resetPasswordForm(w http.ResponseWriter, r *http.Request) {
-	app.render(w, r, "resetpassword.page.tmpl", &templateData{
-		Form: forms.New(nil),
+func (app *application) renderTokens(w http.ResponseWriter, r *http.Request, form *forms.Form) {
+	tokens, err := app.tokens.List(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.render(w, r, "tokens.page.tmpl", &templateData{
+		Form:     form,
+		Tokens:   tokens,
+		NewToken: app.session.PopString(r, "newToken"),
 	})
 }
 
-// 添加处理提交的重置密码的处理函数
-func (app *application) resetPassword(w http.ResponseWriter, r *http.Request) {
+// 创建一个新的API令牌，令牌明文通过session传给下一个页面，只展示一次
+func (app *application) createToken(w http.ResponseWriter, r *http.Request) {
 	err := r.ParseForm()
 	if err != nil {
 		app.clientError(w, http.StatusBadRequest)
 		return
 	}
 
-	// 检验表单中传来的两个密码,利用到了form.go和helpers.go
 	form := forms.New(r.PostForm)
-	form.Required("new_password", "confirm_password")
-	form.MinLength("new_password", 8)
-	form.Matches("new_password", "confirm_password")
+	form.Required("name", "scope")
+	form.MaxLength("name", 100)
+	form.PermittedValues("scope", models.ScopeRead, models.ScopeWrite)
 
-	// 如果上面的几个检验出现错误
 	if !form.Valid() {
-		app.render(w, r, "resetpassword.page.tmpl", &templateData{Form: form})
+		app.renderTokens(w, r, form)
 		return
 	}
 
-	// 如果没有格式错误，直接获取当前用户ID，仅对已经登录的用户
-	userID, ok := app.session.Get(r, "userID").(int)
+	token, err := app.tokens.Insert(app.authenticatedUser(r).ID, form.Get("name"), form.Get("scope"))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
 
-	if !ok {
-		app.clientError(w, http.StatusUnauthorized)
+	app.session.Put(r, "newToken", token)
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+// 撤销一个API令牌，只能撤销自己的令牌
+func (app *application) revokeToken(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(r.URL.Query().Get(":id"))
+	if err != nil || id < 1 {
+		app.notFound(w)
 		return
 	}
 
-	err = app.users.UpdatePassword(userID, form.Get("new_password"))
+	err = app.tokens.Delete(app.authenticatedUser(r).ID, id)
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return
+	} else if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "令牌已撤销")
+	http.Redirect(w, r, "/user/tokens", http.StatusSeeOther)
+}
+
+// 展示当前用户所有登录中的会话
+func (app *application) sessionsPage(w http.ResponseWriter, r *http.Request) {
+	records, err := app.session.Store.List(app.authenticatedUser(r).ID)
 	if err != nil {
 		app.serverError(w, err)
 		return
 	}
-	//
-	app.session.Put(r, "flash", "密码修改成功！")
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+
+	app.render(w, r, "sessions.page.tmpl", &templateData{
+		Sessions:  records,
+		SessionID: app.session.ID(r),
+	})
+}
+
+// 撤销一个会话，对应的设备下一次请求时就会变成未登录状态
+func (app *application) revokeSession(w http.ResponseWriter, r *http.Request) {
+	records, err := app.session.Store.List(app.authenticatedUser(r).ID)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	// 只能撤销自己的会话，当前会话应该通过登出结束
+	id := r.URL.Query().Get(":id")
+	found := false
+	for _, rec := range records {
+		if rec.ID == id && id != app.session.ID(r) {
+			found = true
+		}
+	}
+	if !found {
+		app.notFound(w)
+		return
+	}
+
+	err = app.session.Store.Delete(id)
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "会话已撤销")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
+}
+
+// 退出当前会话之外的所有设备
+func (app *application) revokeOtherSessions(w http.ResponseWriter, r *http.Request) {
+	err := app.session.Store.DeleteUser(app.authenticatedUser(r).ID, app.session.ID(r))
+	if err != nil {
+		app.serverError(w, err)
+		return
+	}
+
+	app.session.Put(r, "flash", "已退出其它所有设备")
+	http.Redirect(w, r, "/user/sessions", http.StatusSeeOther)
 }
 
 // 用于测试
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"archive/zip"
 	"bytes"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/mailer"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models/mock"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/totp"
+	"io/ioutil"
+	"log"
 	"net/http"
 	"net/url"
+	"regexp"
+	"strings"
 	"testing"
+	"time"
 )
 
 // 这里的测试都是在用testutils_test.go中抽象出的逻辑代码使其配合表测试具体化
@@ -40,13 +50,12 @@ func TestShowSnippet(t *testing.T) {
 		wantCode int
 		wantBody []byte
 	}{
-		{"Valid ID", "/snippet/1", http.StatusOK, []byte("An old silent pond...")},
-		{"Non-existent ID", "/snippet/2", http.StatusNotFound, nil},
-		{"Negative ID", "/snippet/-1", http.StatusNotFound, nil},
-		{"Decimal ID", "/snippet/1.23", http.StatusNotFound, nil},
-		{"String ID", "/snippet/foo", http.StatusNotFound, nil},
-		{"Empty ID", "/snippet/", http.StatusNotFound, nil},
-		{"Trailing slash", "/snippet/1/", http.StatusNotFound, nil},
+		{"Valid slug", "/s/r7Gx2kQpLm9w", http.StatusOK, []byte("An old silent pond...")},
+		{"Author name", "/s/r7Gx2kQpLm9w", http.StatusOK, []byte("by ltx")},
+		{"Non-existent slug", "/s/doesNotExist", http.StatusNotFound, nil},
+		{"Empty slug", "/s/", http.StatusNotFound, nil},
+		{"Trailing slash", "/s/r7Gx2kQpLm9w/", http.StatusNotFound, nil},
+		{"Encrypted", "/s/e8Vc4jQm1Zs5", http.StatusOK, []byte(`data-ciphertext='v1.PB8wP2KwTscx0-Yc.`)},
 	}
 
 	for _, tt := range tests {
@@ -63,6 +72,217 @@ func TestShowSnippet(t *testing.T) {
 	}
 }
 
+// 阅后即焚的snippet在GET时只展示确认页面，提交确认表单之后才展示内容并删除
+func TestBurnAfterReading(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string // 为空表示没有登录
+		method     string
+		urlPath    string
+		wantCode   int
+		wantBody   []byte
+		unwantBody []byte
+	}{
+		{"Interstitial", "", http.MethodGet, "/s/b9Rk3mVt6Hy2", http.StatusOK, []byte("查看并删除"), []byte("The light of a candle")},
+		{"Creator interstitial", "1207793251@qq.com", http.MethodGet, "/s/b9Rk3mVt6Hy2", http.StatusOK, []byte("snippet已经创建"), []byte("is transferred to another candle")},
+		{"Raw", "", http.MethodGet, "/s/b9Rk3mVt6Hy2/raw", http.StatusNotFound, nil, nil},
+		{"History", "", http.MethodGet, "/s/b9Rk3mVt6Hy2/history", http.StatusNotFound, nil, nil},
+		{"Numeric ID", "", http.MethodGet, "/snippet/5", http.StatusNotFound, nil, nil},
+		{"Confirm", "", http.MethodPost, "/s/b9Rk3mVt6Hy2", http.StatusOK, []byte("is transferred to another candle"), []byte("/s/b9Rk3mVt6Hy2/raw")},
+		{"Confirm ordinary snippet", "", http.MethodPost, "/s/r7Gx2kQpLm9w", http.StatusSeeOther, nil, nil},
+		{"Confirm non-existent slug", "", http.MethodPost, "/s/doesNotExist", http.StatusNotFound, nil, nil},
+		{"Anonymized interstitial", "", http.MethodGet, "/s/a4Jt6nBw2Qx9", http.StatusOK, []byte("查看并删除"), []byte("brilliant-hued hibiscus")},
+		{"Anonymized raw", "", http.MethodGet, "/s/a4Jt6nBw2Qx9/raw", http.StatusNotFound, nil, nil},
+		{"Anonymized history", "", http.MethodGet, "/s/a4Jt6nBw2Qx9/history", http.StatusNotFound, nil, nil},
+		{"Anonymized confirm", "", http.MethodPost, "/s/a4Jt6nBw2Qx9", http.StatusOK, []byte("brilliant-hued hibiscus"), nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+			csrfToken := extractCSRFToken(t, body)
+			if tt.email != "" {
+				csrfToken = ts.login(t, tt.email)
+			}
+
+			var code int
+			if tt.method == http.MethodPost {
+				code, _, body = ts.postForm(t, tt.urlPath, url.Values{"csrf_token": {csrfToken}})
+			} else {
+				code, _, body = ts.get(t, tt.urlPath)
+			}
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if tt.unwantBody != nil && bytes.Contains(body, tt.unwantBody) {
+				t.Errorf("want body not to contain %q", tt.unwantBody)
+			}
+		})
+	}
+}
+
+func TestSnippetPassword(t *testing.T) {
+	tests := []struct {
+		name       string
+		email      string // 为空表示没有登录
+		password   string // 为空表示不输入密码
+		urlPath    string
+		wantCode   int
+		wantBody   []byte
+		unwantBody []byte
+	}{
+		{"Prompt", "", "", "/s/k2Pw7sLd3Nx8", http.StatusOK, []byte("输入密码之后才能查看"), []byte("a worm digs silently")},
+		{"Owner", "1207793251@qq.com", "", "/s/k2Pw7sLd3Nx8", http.StatusOK, []byte("a worm digs silently"), []byte("输入密码之后才能查看")},
+		{"Other user", "alice@example.com", "", "/s/k2Pw7sLd3Nx8", http.StatusOK, []byte("输入密码之后才能查看"), []byte("a worm digs silently")},
+		{"Raw", "", "", "/s/k2Pw7sLd3Nx8/raw", http.StatusSeeOther, nil, nil},
+		{"Wrong password", "", "wrong", "/s/k2Pw7sLd3Nx8", http.StatusOK, []byte("输入密码之后才能查看"), []byte("a worm digs silently")},
+		{"Correct password", "", "open sesame", "/s/k2Pw7sLd3Nx8", http.StatusOK, []byte("a worm digs silently"), []byte("输入密码之后才能查看")},
+		{"Raw after password", "", "open sesame", "/s/k2Pw7sLd3Nx8/raw", http.StatusOK, []byte("a worm digs silently"), nil},
+		{"Anonymized prompt", "", "", "/s/q6Lf1xZr8Tb3", http.StatusOK, []byte("输入密码之后才能查看"), []byte("how pleasing with sandals")},
+		{"Anonymized raw", "", "", "/s/q6Lf1xZr8Tb3/raw", http.StatusSeeOther, nil, nil},
+		{"Ordinary snippet", "", "", "/s/r7Gx2kQpLm9w", http.StatusOK, []byte("An old silent pond..."), []byte("输入密码之后才能查看")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/login")
+			csrfToken := extractCSRFToken(t, body)
+			if tt.email != "" {
+				csrfToken = ts.login(t, tt.email)
+			}
+
+			if tt.password != "" {
+				form := url.Values{"password": {tt.password}, "csrf_token": {csrfToken}}
+				code, header, _ := ts.postForm(t, "/s/k2Pw7sLd3Nx8/unlock", form)
+				if code != http.StatusOK && code != http.StatusSeeOther {
+					t.Fatalf("unlock: got %d", code)
+				}
+				if code == http.StatusSeeOther && header.Get("Location") != "/s/k2Pw7sLd3Nx8" {
+					t.Errorf("unlock: want redirect to snippet; got %q", header.Get("Location"))
+				}
+			}
+
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if tt.unwantBody != nil && bytes.Contains(body, tt.unwantBody) {
+				t.Errorf("want body not to contain %q", tt.unwantBody)
+			}
+		})
+	}
+}
+
+// 同一个snippet连续输错密码之后需要等待，即使密码正确
+func TestSnippetPasswordThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	unlock := func(password string) (int, http.Header, []byte) {
+		form := url.Values{"password": {password}, "csrf_token": {csrfToken}}
+		return ts.postForm(t, "/s/k2Pw7sLd3Nx8/unlock", form)
+	}
+
+	for i := 0; i < snippetPolicy.Free; i++ {
+		code, _, body := unlock("wrong")
+		if code != http.StatusOK || !bytes.Contains(body, []byte("密码错误")) {
+			t.Fatalf("attempt %d: want wrong password error; got %d", i+1, code)
+		}
+	}
+
+	// 被拒绝的这次尝试同样计入失败次数，所以等待时间已经翻倍
+	code, header, body := unlock("open sesame")
+	if code != http.StatusTooManyRequests {
+		t.Errorf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if header.Get("Retry-After") != "4" {
+		t.Errorf("want Retry-After 4; got %q", header.Get("Retry-After"))
+	}
+	if !bytes.Contains(body, []byte("请在4秒后重试")) {
+		t.Error("want lockout message in body")
+	}
+}
+
+// 检查密码之前先计入失败次数，密码正确之后要撤销，否则知道密码的访客多了snippet也会被锁定
+func TestSnippetPasswordCorrectNotCounted(t *testing.T) {
+	app := newTestApplication(t)
+
+	for i := 0; i <= snippetPolicy.Free; i++ {
+		// 每次使用新的客户端，session中没有解锁的记录
+		ts := newTestServer(t, app.routes())
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{"password": {"open sesame"}, "csrf_token": {extractCSRFToken(t, body)}}
+		code, _, _ := ts.postForm(t, "/s/k2Pw7sLd3Nx8/unlock", form)
+		ts.Close()
+		if code != http.StatusSeeOther {
+			t.Fatalf("visitor %d: want %d; got %d", i+1, http.StatusSeeOther, code)
+		}
+	}
+}
+
+// 以前使用数字id的地址重定向到slug地址，只有公开的snippet使用永久重定向
+func TestRedirectSnippetID(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string // 为空表示没有登录
+		urlPath      string
+		wantCode     int
+		wantLocation string
+	}{
+		{"Valid ID", "", "/snippet/1", http.StatusMovedPermanently, "/s/r7Gx2kQpLm9w"},
+		{"Raw", "", "/snippet/1/raw", http.StatusMovedPermanently, "/s/r7Gx2kQpLm9w/raw"},
+		{"Revision", "", "/snippet/1/history/2", http.StatusMovedPermanently, "/s/r7Gx2kQpLm9w/history/2"},
+		{"Query string", "", "/snippet/1/diff?from=1&to=2", http.StatusMovedPermanently, "/s/r7Gx2kQpLm9w/diff?from=1&to=2"},
+		{"Non-existent ID", "", "/snippet/2", http.StatusNotFound, ""},
+		{"Negative ID", "", "/snippet/-1", http.StatusNotFound, ""},
+		{"Decimal ID", "", "/snippet/1.23", http.StatusNotFound, ""},
+		{"String ID", "", "/snippet/foo", http.StatusNotFound, ""},
+		{"Empty ID", "", "/snippet/", http.StatusNotFound, ""},
+		{"Trailing slash", "", "/snippet/1/", http.StatusNotFound, ""},
+		{"Unlisted", "", "/snippet/3", http.StatusNotFound, ""},
+		{"Unlisted owner", "1207793251@qq.com", "/snippet/3", http.StatusFound, "/s/u3Nq8vTzYc1e"},
+		{"Private owner", "1207793251@qq.com", "/snippet/4/edit", http.StatusFound, "/s/p5Kd0wHsJa4f/edit"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.email != "" {
+				ts.login(t, tt.email)
+			}
+
+			code, header, _ := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+		})
+	}
+}
+
 // 先测试 GET /user/signup得到CSRF令牌，在此基础上测试 POST /user/signup
 func TestSignupUser(t *testing.T) {
 	// Make the end-to-end test
@@ -83,16 +303,16 @@ func TestSignupUser(t *testing.T) {
 		wantCode     int
 		wantBody     []byte
 	}{
-		{"Valid submission", "Bob", "bob@example.com", "validPassword", csrfToken, http.StatusOK, []byte("Valid submission")},
-		{"Empty name", "", "bob@example.com", "validPa$$word", csrfToken, http.StatusBadRequest, []byte("Empty name error message")},
-		{"Empty email", "Bob", "", "validPassword", "csrfToken", http.StatusOK, []byte("Empty email error message")},
-		{"Empty password", "Bob", "bob@example.com", "", "csrfToken", http.StatusOK, []byte("Empty password error message")},
-		{"Invalid email (incomplete domain)", "Bob", "bob@example.", "validPassword", "csrfToken", http.StatusOK, []byte("Invalid email error message")},
-		{"Invalid email (missing @)", "Bob", "bobexample.com", "validPassword", "csrfToken", http.StatusOK, []byte("Invalid email error message")},
-		{"Invalid email (missing local part)", "Bob", "@example.com", "validPassword", "csrfToken", http.StatusOK, []byte("Invalid email error message")},
-		{"Short password", "Bob", "bobexample.com", "password", "csrfToken", http.StatusOK, []byte("Short password error message")},
-		{"Duplicate email", "Bob", "dupeexample.com", "validPassword", "csrfToken", http.StatusOK, []byte("Duplicate email error message")},
-		{"Invalid CSRF Token", "", "", "", "wrongToken", http.StatusBadRequest, []byte("Invalid CSRF Token error message")},
+		{"Valid submission", "Bob", "bob@example.com", "validPa$$word", csrfToken, http.StatusSeeOther, nil},
+		{"Empty name", "", "bob@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("This field cannot be blank")},
+		{"Empty email", "Bob", "", "validPa$$word", csrfToken, http.StatusOK, []byte("This field cannot be blank")},
+		{"Empty password", "Bob", "bob@example.com", "", csrfToken, http.StatusOK, []byte("This field cannot be blank")},
+		{"Invalid email (incomplete domain)", "Bob", "bob@example.", "validPa$$word", csrfToken, http.StatusOK, []byte("This field is invalid")},
+		{"Invalid email (missing @)", "Bob", "bobexample.com", "validPa$$word", csrfToken, http.StatusOK, []byte("This field is invalid")},
+		{"Invalid email (missing local part)", "Bob", "@example.com", "validPa$$word", csrfToken, http.StatusOK, []byte("This field is invalid")},
+		{"Short password", "Bob", "bob@example.com", "pa$$", csrfToken, http.StatusOK, []byte("This feld is too short")},
+		{"Duplicate email", "Bob", "1207793251@qq.com", "validPa$$word", csrfToken, http.StatusOK, []byte("Address is already in use")},
+		{"Invalid CSRF Token", "", "", "", "wrongToken", http.StatusBadRequest, nil},
 	}
 
 	for _, tt := range tests {
@@ -112,3 +332,1412 @@ func TestSignupUser(t *testing.T) {
 		})
 	}
 }
+
+func TestEditSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// 未登录时会被重定向到登录页面
+	code, headers, _ := ts.get(t, "/s/r7Gx2kQpLm9w/edit")
+	if code != http.StatusFound || headers.Get("Location") != "/user/login" {
+		t.Errorf("want redirect to /user/login; got %d %q", code, headers.Get("Location"))
+	}
+
+	csrfToken := ts.login(t, "1207793251@qq.com")
+
+	code, _, body := ts.get(t, "/s/r7Gx2kQpLm9w/edit")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("An old silent pond...")) {
+		t.Errorf("want body to contain the current content")
+	}
+
+	// 加密的snippet和不加密的snippet不能互相转换
+	ciphertext := "v1.PB8wP2KwTscx0-Yc.KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA"
+	tests := []struct {
+		name     string
+		urlPath  string
+		title    string
+		content  string
+		format   string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Valid submission", "/s/r7Gx2kQpLm9w/edit", "New title", "New content", "", http.StatusSeeOther, nil},
+		{"Empty title", "/s/r7Gx2kQpLm9w/edit", "", "New content", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Long title", "/s/r7Gx2kQpLm9w/edit", strings.Repeat("a", 101), "New content", "", http.StatusOK, []byte("This field is too long")},
+		{"Non-existent slug", "/s/doesNotExist/edit", "New title", "New content", "", http.StatusNotFound, nil},
+		{"Encrypted", "/s/e8Vc4jQm1Zs5/edit", "New title", ciphertext, "encrypted", http.StatusSeeOther, nil},
+		{"Encrypt plain snippet", "/s/r7Gx2kQpLm9w/edit", "New title", ciphertext, "encrypted", http.StatusOK, []byte("Cannot convert between encrypted and unencrypted snippets")},
+		{"Decrypt encrypted snippet", "/s/e8Vc4jQm1Zs5/edit", "New title", "New content", "plain", http.StatusOK, []byte("Cannot convert between encrypted and unencrypted snippets")},
+	}
+
+	// 加密的snippet只能修改标题等，密文作为隐藏字段原样提交
+	code, _, body = ts.get(t, "/s/e8Vc4jQm1Zs5/edit")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte(`<input type="hidden" name="format" value="encrypted">`)) || bytes.Contains(body, []byte("<textarea")) {
+		t.Errorf("want ciphertext in hidden fields")
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("format", tt.format)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestDeleteSnippet(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		urlPath  string
+		wantCode int
+	}{
+		{"Owner", "1207793251@qq.com", "/s/r7Gx2kQpLm9w/delete", http.StatusSeeOther},
+		{"Not owner", "alice@example.com", "/s/r7Gx2kQpLm9w/delete", http.StatusForbidden},
+		{"Non-existent slug", "1207793251@qq.com", "/s/doesNotExist/delete", http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// 每个子测试使用独立的服务器和cookie jar，以不同的用户登录
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, tt.email)
+
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestSnippetHistory(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"History", "/s/r7Gx2kQpLm9w/history", http.StatusOK, []byte("/s/r7Gx2kQpLm9w/history/1")},
+		{"Non-existent snippet", "/s/doesNotExist/history", http.StatusNotFound, nil},
+		{"Revision", "/s/r7Gx2kQpLm9w/history/1", http.StatusOK, []byte("An old pond...")},
+		{"Non-existent revision", "/s/r7Gx2kQpLm9w/history/3", http.StatusNotFound, nil},
+		{"String revision", "/s/r7Gx2kQpLm9w/history/foo", http.StatusNotFound, nil},
+		{"Default diff", "/s/r7Gx2kQpLm9w/diff", http.StatusOK, []byte("<span class='delete'>-An old pond...</span>")},
+		{"Diff hunk header", "/s/r7Gx2kQpLm9w/diff?from=1&to=2", http.StatusOK, []byte("@@ -1 &#43;1 @@")}, // html/template会转义+号
+		{"Same revision", "/s/r7Gx2kQpLm9w/diff?from=2&to=2", http.StatusOK, []byte("两个版本的内容没有区别")},
+		{"Non-existent diff revision", "/s/r7Gx2kQpLm9w/diff?from=5", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRestoreRevision(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string
+		urlPath  string
+		wantCode int
+	}{
+		{"Owner", "1207793251@qq.com", "/s/r7Gx2kQpLm9w/history/1/restore", http.StatusSeeOther},
+		{"Not owner", "alice@example.com", "/s/r7Gx2kQpLm9w/history/1/restore", http.StatusForbidden},
+		{"Non-existent revision", "1207793251@qq.com", "/s/r7Gx2kQpLm9w/history/9/restore", http.StatusNotFound},
+		{"Encrypted", "1207793251@qq.com", "/s/e8Vc4jQm1Zs5/history/1/restore", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, tt.email)
+
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := ts.postForm(t, tt.urlPath, form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+}
+
+func TestHome(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	cursor := (&models.Cursor{Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), ID: 7}).String()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"First page", "/", http.StatusOK, []byte("An old silent pond")},
+		{"Later page", "/?after=" + cursor, http.StatusOK, []byte("nothing to see here")},
+		{"Invalid cursor", "/?after=foo", http.StatusBadRequest, nil},
+		{"Browse", "/snippets", http.StatusOK, []byte("An old silent pond")},
+		{"Browse limit", "/snippets?limit=20", http.StatusOK, []byte("<strong>20</strong>")},
+		{"Browse invalid limit", "/snippets?limit=1000", http.StatusOK, []byte("<strong>10</strong>")},
+		{"Browse invalid cursor", "/snippets?before=%21", http.StatusBadRequest, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestSearch(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Empty query", "/search", http.StatusOK, []byte(`name="q"`)},
+		{"Match", "/search?q=silent", http.StatusOK, []byte("An old <mark>silent</mark> pond...")},
+		{"No match", "/search?q=frog", http.StatusOK, []byte("没有找到")},
+		{"Second page", "/search?q=silent&page=2", http.StatusOK, []byte("没有找到")},
+		{"Invalid page", "/search?q=silent&page=0", http.StatusNotFound, nil},
+		{"String page", "/search?q=silent&page=foo", http.StatusNotFound, nil},
+		{"Page too large", "/search?q=silent&page=101", http.StatusNotFound, nil},
+		{"Page out of range", "/search?q=silent&page=99999999999999999999", http.StatusNotFound, nil},
+		{"Long query", "/search?q=" + strings.Repeat("a", 101), http.StatusOK, []byte("This field is too long")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestCreateSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "1207793251@qq.com")
+
+	tests := []struct {
+		name         string
+		title        string
+		tags         string
+		visibility   string
+		expires      string
+		password     string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"Valid submission", "A title", "go, SQL，go", "", "7", "", http.StatusSeeOther, "/s/n2Wb6yRfEo7s", nil},
+		{"No tags", "A title", "", "", "7", "", http.StatusSeeOther, "/s/n2Wb6yRfEo7s", nil},
+		{"Empty title", "", "go", "", "7", "", http.StatusOK, "", []byte("This field cannot be blank")},
+		{"Too many tags", "A title", "a,b,c,d,e,f", "", "7", "", http.StatusOK, "", []byte("Too many tags (maximum is 5)")},
+		{"Invalid tag", "A title", "go, bad tag", "", "7", "", http.StatusOK, "", []byte("Invalid tag")},
+		{"Tag too long", "A title", strings.Repeat("a", 31), "", "7", "", http.StatusOK, "", []byte("Invalid tag")},
+		{"Private", "A title", "", "private", "7", "", http.StatusSeeOther, "/s/n2Wb6yRfEo7s", nil},
+		{"Unlisted", "A title", "", "unlisted", "7", "", http.StatusSeeOther, "/s/n2Wb6yRfEo7s", nil},
+		{"Invalid visibility", "A title", "", "friends", "7", "", http.StatusOK, "", []byte("This field is invalid")},
+		{"Burn after reading", "A title", "", "", "burn", "", http.StatusSeeOther, "/s/n2Wb6yRfEo7s", nil},
+		{"Invalid expires", "A title", "", "", "30", "", http.StatusOK, "", []byte("This field is invalid")},
+		{"Password", "A title", "", "", "7", "open sesame", http.StatusSeeOther, "/s/n2Wb6yRfEo7s", nil},
+		{"Password too long", "A title", "", "", "7", strings.Repeat("a", 73), http.StatusOK, "", []byte("This field is too long (maximum is 72 bytes)")},
+		{"Multibyte password too long", "A title", "", "", "7", strings.Repeat("密", 30), http.StatusOK, "", []byte("This field is too long (maximum is 72 bytes)")},
+		{"Multibyte password", "A title", "", "", "7", strings.Repeat("密", 24), http.StatusSeeOther, "/s/n2Wb6yRfEo7s", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", "Some content")
+			form.Add("expires", tt.expires)
+			form.Add("tags", tt.tags)
+			form.Add("visibility", tt.visibility)
+			form.Add("password", tt.password)
+			form.Add("csrf_token", csrfToken)
+			code, header, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body %s to contain %q", body, tt.wantBody)
+			}
+		})
+	}
+}
+
+// 浏览器加密的内容只检查格式和大小，检查失败时不回填密文
+func TestCreateEncryptedSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "1207793251@qq.com")
+
+	iv := "PB8wP2KwTscx0-Yc"
+	ciphertext := "v1." + iv + ".KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA"
+	tests := []struct {
+		name     string
+		title    string
+		content  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Valid submission", "A title", ciphertext, http.StatusSeeOther, nil},
+		{"Plaintext", "A title", "An old silent pond...", http.StatusOK, []byte("Invalid ciphertext")},
+		{"Unknown version", "A title", "v2." + iv + ".KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA", http.StatusOK, []byte("Invalid ciphertext")},
+		{"Short IV", "A title", "v1.PB8wP2KwTscx.KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA", http.StatusOK, []byte("Invalid ciphertext")},
+		{"Missing tag", "A title", "v1." + iv + ".KC6TmuJT", http.StatusOK, []byte("Invalid ciphertext")},
+		{"Padded base64", "A title", "v1." + iv + ".KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA==", http.StatusOK, []byte("Invalid ciphertext")},
+		{"Too large", "A title", "v1." + iv + "." + strings.Repeat("A", 50000), http.StatusOK, []byte("Ciphertext is too large (maximum is 32768 bytes)")},
+		{"Empty title", "", ciphertext, http.StatusOK, []byte("加密的内容没有保留，请重新输入")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("title", tt.title)
+			form.Add("content", tt.content)
+			form.Add("format", "encrypted")
+			form.Add("language", "go")
+			form.Add("expires", "7")
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/snippet/create", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if code == http.StatusOK && bytes.Contains(body, []byte(tt.content)) {
+				t.Error("want content not to be rendered back")
+			}
+		})
+	}
+}
+
+// 不公开的snippet只能通过slug访问，私密的snippet只有作者可以访问，都不出现在公开的列表中
+func TestSnippetVisibility(t *testing.T) {
+	tests := []struct {
+		name     string
+		email    string // 为空表示没有登录
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Public by slug", "", "/s/r7Gx2kQpLm9w", http.StatusOK, []byte("An old silent pond...")},
+		{"Unlisted by ID", "", "/snippet/3", http.StatusNotFound, nil},
+		{"Unlisted raw by ID", "", "/snippet/3/raw", http.StatusNotFound, nil},
+		{"Unlisted history", "", "/snippet/3/history", http.StatusNotFound, nil},
+		{"Unlisted by slug", "", "/s/u3Nq8vTzYc1e", http.StatusOK, []byte("Over the wintry forest")},
+		{"Unlisted raw by slug", "", "/s/u3Nq8vTzYc1e/raw", http.StatusOK, []byte("winds howl in rage")},
+		{"Unlisted notice", "1207793251@qq.com", "/s/u3Nq8vTzYc1e", http.StatusOK, []byte("只有知道链接的人可以访问")},
+		{"Private anonymous", "", "/snippet/4", http.StatusNotFound, nil},
+		{"Private by slug", "", "/s/p5Kd0wHsJa4f", http.StatusNotFound, nil},
+		{"Private other user", "alice@example.com", "/s/p5Kd0wHsJa4f", http.StatusNotFound, nil},
+		{"Private owner", "1207793251@qq.com", "/s/p5Kd0wHsJa4f", http.StatusOK, []byte("First autumn morning")},
+		{"Private owner by slug", "1207793251@qq.com", "/s/p5Kd0wHsJa4f/download", http.StatusOK, []byte("the mirror I stare into")},
+		{"Unknown slug", "", "/s/doesNotExist", http.StatusNotFound, nil},
+		{"Dashboard", "1207793251@qq.com", "/user/snippets", http.StatusOK, []byte("私密")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			if tt.email != "" {
+				ts.login(t, tt.email)
+			}
+
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestShowTag(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Tag", "/tag/haiku", http.StatusOK, []byte("An old silent pond")},
+		{"Unused tag", "/tag/go", http.StatusOK, []byte("nothing to see here")},
+		{"Invalid tag", "/tag/-go", http.StatusNotFound, nil},
+		{"Tag cloud", "/", http.StatusOK, []byte("<a href='/tag/haiku'")},
+		{"Tags on snippet", "/s/r7Gx2kQpLm9w", http.StatusOK, []byte("#haiku")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestRawSnippet(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	tests := []struct {
+		name            string
+		urlPath         string
+		wantCode        int
+		wantBody        string
+		wantDisposition string
+	}{
+		{"Raw", "/s/r7Gx2kQpLm9w/raw", http.StatusOK, "An old silent pond...", ""},
+		{"Raw non-existent slug", "/s/doesNotExist/raw", http.StatusNotFound, "Not Found\n", ""},
+		{"Download", "/s/r7Gx2kQpLm9w/download", http.StatusOK, "An old silent pond...", "attachment; filename=an-old-silent-pond.txt"},
+		{"Download non-existent slug", "/s/doesNotExist/download", http.StatusNotFound, "Not Found\n", ""},
+		{"Ciphertext", "/s/e8Vc4jQm1Zs5/ciphertext", http.StatusOK, "v1.PB8wP2KwTscx0-Yc.KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA", ""},
+		{"Ciphertext of plain snippet", "/s/r7Gx2kQpLm9w/ciphertext", http.StatusNotFound, "Not Found\n", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, headers, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if string(body) != tt.wantBody {
+				t.Errorf("want body %q; got %q", tt.wantBody, body)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			if ct := headers.Get("Content-Type"); ct != "text/plain; charset=utf-8" {
+				t.Errorf("want content type %q; got %q", "text/plain; charset=utf-8", ct)
+			}
+			if cd := headers.Get("Content-Disposition"); cd != tt.wantDisposition {
+				t.Errorf("want content disposition %q; got %q", tt.wantDisposition, cd)
+			}
+		})
+	}
+}
+
+func TestSnippetFilename(t *testing.T) {
+	tests := []struct {
+		name    string
+		snippet *models.Snippet
+		want    string
+	}{
+		{"Plain text", &models.Snippet{ID: 1, Title: "An old silent pond"}, "an-old-silent-pond.txt"},
+		{"Go", &models.Snippet{ID: 1, Title: "Hello, World!", Language: "go"}, "hello-world.go"},
+		{"Markdown", &models.Snippet{ID: 1, Title: "Notes", Format: models.FormatMarkdown}, "notes.md"},
+		{"Unicode title", &models.Snippet{ID: 1, Title: "漂流 盒子", Language: "python"}, "漂流-盒子.py"},
+		{"Empty title", &models.Snippet{ID: 7, Title: "../", Language: "sql"}, "snippet-7.sql"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := snippetFilename(tt.snippet)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestTokens(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	// 未登录时重定向到登录页面
+	code, header, _ := ts.get(t, "/user/tokens")
+	if code != http.StatusSeeOther && code != http.StatusFound {
+		t.Errorf("want redirect; got %d", code)
+	}
+	if loc := header.Get("Location"); loc != "/user/login" {
+		t.Errorf("want redirect to /user/login; got %q", loc)
+	}
+
+	csrfToken := ts.login(t, "1207793251@qq.com")
+
+	_, _, body := ts.get(t, "/user/tokens")
+	for _, want := range []string{"Deploy script", "CI read", "/user/tokens/1/revoke"} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want body to contain %q", want)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		tokName  string
+		scope    string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Missing name", "/user/tokens", "", "read", http.StatusOK, []byte("This field cannot be blank")},
+		{"Invalid scope", "/user/tokens", "Backup job", "admin", http.StatusOK, []byte("This field is invalid")},
+		{"Revoke", "/user/tokens/1/revoke", "", "", http.StatusSeeOther, nil},
+		{"Revoke non-existent", "/user/tokens/3/revoke", "", "", http.StatusNotFound, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("name", tt.tokName)
+			form.Add("scope", tt.scope)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, tt.urlPath, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// 新令牌只在创建后的下一个页面展示一次
+	form := url.Values{}
+	form.Add("name", "Backup job")
+	form.Add("scope", "write")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ = ts.postForm(t, "/user/tokens", form)
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	_, _, body = ts.get(t, "/user/tokens")
+	if !bytes.Contains(body, []byte("sbx_newtoken")) {
+		t.Error("want new token to be shown after creation")
+	}
+	_, _, body = ts.get(t, "/user/tokens")
+	if bytes.Contains(body, []byte("sbx_newtoken")) {
+		t.Error("want new token to be shown only once")
+	}
+}
+
+func TestForgotPassword(t *testing.T) {
+	tests := []struct {
+		name         string
+		email        string
+		wantCode     int
+		wantLocation string
+		wantMail     []byte
+	}{
+		{"Registered email", "1207793251@qq.com", http.StatusSeeOther, "/user/login", []byte("https://snippetbox.test/user/resetpassword/newresettoken")},
+		{"Unknown email", "nobody@example.com", http.StatusSeeOther, "/user/login", nil},
+		{"Invalid email", "nobody", http.StatusOK, "", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			var mail bytes.Buffer
+			app.mailer = &mailer.Log{Logger: log.New(&mail, "", 0)}
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			_, _, body := ts.get(t, "/user/forgotpassword")
+			form := url.Values{}
+			form.Add("email", tt.email)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			code, header, _ := ts.postForm(t, "/user/forgotpassword", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+			if tt.wantMail == nil && mail.Len() > 0 {
+				t.Errorf("want no mail; got %q", mail.String())
+			}
+			if !bytes.Contains(mail.Bytes(), tt.wantMail) {
+				t.Errorf("want mail to contain %q; got %q", tt.wantMail, mail.String())
+			}
+		})
+	}
+}
+
+func TestRecoverPassword(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, body := ts.get(t, "/user/resetpassword/validtoken")
+	if code != http.StatusOK {
+		t.Errorf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte(`action="/user/resetpassword/validtoken"`)) {
+		t.Error("want form to post back to the token URL")
+	}
+	if header.Get("Referrer-Policy") != "no-referrer" {
+		t.Error("want Referrer-Policy: no-referrer")
+	}
+	csrfToken := extractCSRFToken(t, body)
+
+	code, header, _ = ts.get(t, "/user/resetpassword/badtoken")
+	if code != http.StatusSeeOther || header.Get("Location") != "/user/forgotpassword" {
+		t.Errorf("want redirect to /user/forgotpassword; got %d %q", code, header.Get("Location"))
+	}
+
+	tests := []struct {
+		name         string
+		urlPath      string
+		password     string
+		confirm      string
+		wantCode     int
+		wantLocation string
+	}{
+		{"Valid", "/user/resetpassword/validtoken", "newPa$$word", "newPa$$word", http.StatusSeeOther, "/user/login"},
+		{"Mismatch", "/user/resetpassword/validtoken", "newPa$$word", "otherPa$$word", http.StatusOK, ""},
+		{"Too short", "/user/resetpassword/validtoken", "short", "short", http.StatusOK, ""},
+		{"Invalid token", "/user/resetpassword/badtoken", "newPa$$word", "newPa$$word", http.StatusSeeOther, "/user/forgotpassword"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("new_password", tt.password)
+			form.Add("confirm_password", tt.confirm)
+			form.Add("csrf_token", csrfToken)
+			code, header, _ := ts.postForm(t, tt.urlPath, form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+		})
+	}
+}
+
+func TestVerifyEmail(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	alice := &models.User{ID: 2, Email: "alice@example.com"}
+	valid := app.verificationToken(alice, time.Now().Add(time.Hour))
+
+	tests := []struct {
+		name         string
+		token        string
+		wantLocation string
+	}{
+		{"Valid", valid, "/"},
+		{"Tampered signature", valid[:len(valid)-2] + "xx", "/user/verify"},
+		{"Other user", strings.Replace(valid, "2.", "1.", 1), "/user/verify"},
+		{"Expired", app.verificationToken(alice, time.Now().Add(-time.Hour)), "/user/verify"},
+		{"Changed email", app.verificationToken(&models.User{ID: 2, Email: "old@example.com"}, time.Now().Add(time.Hour)), "/user/verify"},
+		{"Malformed", "foo", "/user/verify"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, header, _ := ts.get(t, "/user/verify/"+tt.token)
+
+			if code != http.StatusSeeOther {
+				t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+		})
+	}
+}
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	tests := []struct {
+		name            string
+		email           string
+		requireVerified bool
+		wantCode        int
+		wantAPICode     int
+	}{
+		{"Verified", "1207793251@qq.com", true, http.StatusOK, http.StatusCreated},
+		{"Unverified", "alice@example.com", true, http.StatusSeeOther, http.StatusForbidden},
+		{"Unverified without option", "alice@example.com", false, http.StatusOK, http.StatusCreated},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			app.requireVerified = tt.requireVerified
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			ts.login(t, tt.email)
+			code, _, _ := ts.get(t, "/snippet/create")
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+
+			body := `{"title":"O snail","content":"Climb Mount Fuji","expires":7}`
+			code, _, _ = ts.apiRequest(t, http.MethodPost, "/api/v1/snippets", basicAuth(tt.email), body)
+			if code != tt.wantAPICode {
+				t.Errorf("want API %d; got %d", tt.wantAPICode, code)
+			}
+		})
+	}
+}
+
+func TestResendVerification(t *testing.T) {
+	app := newTestApplication(t)
+	var mail bytes.Buffer
+	app.mailer = &mailer.Log{Logger: log.New(&mail, "", 0)}
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com")
+
+	_, _, body := ts.get(t, "/user/verify")
+	if !bytes.Contains(body, []byte("重新发送验证邮件")) {
+		t.Error("want resend button for unverified user")
+	}
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/verify", form)
+	if code != http.StatusSeeOther {
+		t.Errorf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	if !bytes.Contains(mail.Bytes(), []byte("To: alice@example.com")) || !bytes.Contains(mail.Bytes(), []byte("https://snippetbox.test/user/verify/2.")) {
+		t.Errorf("want verification mail; got %q", mail.String())
+	}
+}
+
+func TestLoginTwoFactor(t *testing.T) {
+	code, err := totp.Code(mock.MockTOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name         string
+		code         string
+		wantCode     int
+		wantLocation string
+		wantBody     []byte
+	}{
+		{"TOTP code", code, http.StatusSeeOther, "/snippet/create", nil},
+		{"Recovery code", "aaaaa-bbbbb", http.StatusSeeOther, "/snippet/create", nil},
+		{"Wrong code", "000000", http.StatusOK, "", []byte("验证码错误")},
+		{"Empty code", "", http.StatusOK, "", []byte("This field cannot be blank")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			// 没有通过密码验证时不能进入第二步
+			status, header, _ := ts.get(t, "/user/login/2fa")
+			if status != http.StatusSeeOther || header.Get("Location") != "/user/login" {
+				t.Errorf("want redirect to /user/login; got %d %q", status, header.Get("Location"))
+			}
+
+			_, _, body := ts.get(t, "/user/login")
+			form := url.Values{}
+			form.Add("email", "carol@example.com")
+			form.Add("password", "validPa$$word")
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			status, header, _ = ts.postForm(t, "/user/login", form)
+			if status != http.StatusSeeOther || header.Get("Location") != "/user/login/2fa" {
+				t.Fatalf("want redirect to /user/login/2fa; got %d %q", status, header.Get("Location"))
+			}
+
+			// 只输入了密码时还没有登录
+			status, _, _ = ts.get(t, "/snippet/create")
+			if status != http.StatusFound {
+				t.Errorf("want %d before second step; got %d", http.StatusFound, status)
+			}
+
+			_, _, body = ts.get(t, "/user/login/2fa")
+			form = url.Values{}
+			form.Add("code", tt.code)
+			form.Add("csrf_token", extractCSRFToken(t, body))
+			status, header, body = ts.postForm(t, "/user/login/2fa", form)
+
+			if status != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, status)
+			}
+			if loc := header.Get("Location"); loc != tt.wantLocation {
+				t.Errorf("want location %q; got %q", tt.wantLocation, loc)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+
+			wantCreate := http.StatusFound
+			if tt.wantLocation != "" {
+				wantCreate = http.StatusOK
+			}
+			status, _, _ = ts.get(t, "/snippet/create")
+			if status != wantCreate {
+				t.Errorf("want %d after second step; got %d", wantCreate, status)
+			}
+		})
+	}
+}
+
+func TestTwoFactorSetup(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "alice@example.com")
+
+	_, _, body := ts.get(t, "/user/2fa")
+	if !bytes.Contains(body, []byte(`action="/user/2fa/setup"`)) {
+		t.Error("want setup form when two-factor authentication is off")
+	}
+
+	form := url.Values{}
+	form.Add("csrf_token", csrfToken)
+	status, header, _ := ts.postForm(t, "/user/2fa/setup", form)
+	if status != http.StatusSeeOther || header.Get("Location") != "/user/2fa/setup" {
+		t.Errorf("want redirect to /user/2fa/setup; got %d %q", status, header.Get("Location"))
+	}
+
+	_, _, body = ts.get(t, "/user/2fa/setup")
+	for _, want := range []string{`src="data:image/png;base64,`, mock.MockTOTPSecret} {
+		if !bytes.Contains(body, []byte(want)) {
+			t.Errorf("want setup page to contain %q", want)
+		}
+	}
+
+	form.Set("code", "000000")
+	status, _, body = ts.postForm(t, "/user/2fa/enable", form)
+	if status != http.StatusOK || !bytes.Contains(body, []byte("验证码错误")) {
+		t.Errorf("want wrong code to be rejected; got %d", status)
+	}
+
+	code, err := totp.Code(mock.MockTOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	form.Set("code", code)
+	status, header, _ = ts.postForm(t, "/user/2fa/enable", form)
+	if status != http.StatusSeeOther || header.Get("Location") != "/user/2fa" {
+		t.Errorf("want redirect to /user/2fa; got %d %q", status, header.Get("Location"))
+	}
+
+	// 恢复码只在开启之后展示一次
+	_, _, body = ts.get(t, "/user/2fa")
+	if !bytes.Contains(body, []byte("aaaaa-bbbbb")) {
+		t.Error("want recovery codes after enabling")
+	}
+	_, _, body = ts.get(t, "/user/2fa")
+	if bytes.Contains(body, []byte("aaaaa-bbbbb")) {
+		t.Error("want recovery codes to be shown only once")
+	}
+}
+
+func TestLoginThrottle(t *testing.T) {
+	app := newTestApplication(t)
+	events := &mock.LoginEventModel{}
+	app.loginEvents = events
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	login := func(email, password string) (int, http.Header, []byte) {
+		form := url.Values{}
+		form.Add("email", email)
+		form.Add("password", password)
+		form.Add("csrf_token", csrfToken)
+		return ts.postForm(t, "/user/login", form)
+	}
+
+	// 允许的失败次数之内只提示密码错误
+	for i := 0; i < emailPolicy.Free; i++ {
+		code, _, body := login("1207793251@qq.com", "wrongPa$$word")
+		if code != http.StatusOK || !bytes.Contains(body, []byte("邮箱或密码出现错误")) {
+			t.Fatalf("attempt %d: want invalid credentials error; got %d", i+1, code)
+		}
+	}
+
+	// 之后即使密码正确也需要等待，被拒绝的这次尝试同样计入失败次数，所以等待时间已经翻倍
+	code, header, body := login("1207793251@qq.com", "validPa$$word")
+	if code != http.StatusTooManyRequests {
+		t.Errorf("want %d; got %d", http.StatusTooManyRequests, code)
+	}
+	if header.Get("Retry-After") != "4" {
+		t.Errorf("want Retry-After 4; got %q", header.Get("Retry-After"))
+	}
+	if !bytes.Contains(body, []byte("请在4秒后重试")) {
+		t.Error("want lockout message in body")
+	}
+
+	// 其它邮箱不受影响
+	code, _, _ = login("alice@example.com", "validPa$$word")
+	if code != http.StatusSeeOther {
+		t.Errorf("want other user to log in; got %d", code)
+	}
+
+	got := events.Events()
+	want := []string{"1207793251@qq.com failure", "1207793251@qq.com locked", "alice@example.com success"}
+	if len(got) != emailPolicy.Free+2 || got[0] != want[0] || got[len(got)-2] != want[1] || got[len(got)-1] != want[2] {
+		t.Errorf("want audit events ending in %v; got %v", want, got)
+	}
+}
+
+// 登录之前先计入失败次数，登录成功之后要撤销，否则同一个IP的正常登录也会被锁定
+func TestLoginSuccessNotCounted(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	for i := 0; i <= ipPolicy.Free; i++ {
+		_, _, body := ts.get(t, "/user/login")
+		form := url.Values{}
+		form.Add("email", "alice@example.com")
+		form.Add("password", "validPa$$word")
+		form.Add("csrf_token", extractCSRFToken(t, body))
+		code, _, _ := ts.postForm(t, "/user/login", form)
+		if code != http.StatusSeeOther {
+			t.Fatalf("login %d: want %d; got %d", i+1, http.StatusSeeOther, code)
+		}
+	}
+}
+
+var revokeSessionRX = regexp.MustCompile(`/user/sessions/([0-9a-f]{64})/revoke`)
+
+// 同一个app启动两个测试服务器，相当于同一个用户在两台设备上登录
+func newTwoDevices(t *testing.T) (*testServer, *testServer) {
+	app := newTestApplication(t)
+	return newTestServer(t, app.routes()), newTestServer(t, app.routes())
+}
+
+func TestSessions(t *testing.T) {
+	laptop, phone := newTwoDevices(t)
+	defer laptop.Close()
+	defer phone.Close()
+
+	csrfToken := laptop.login(t, "1207793251@qq.com")
+	phone.login(t, "1207793251@qq.com")
+
+	code, _, body := laptop.get(t, "/user/sessions")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte("当前设备")) {
+		t.Error("want current session to be marked")
+	}
+	// 只有另一台设备的会话可以撤销
+	matches := revokeSessionRX.FindAllSubmatch(body, -1)
+	if len(matches) != 1 {
+		t.Fatalf("want 1 revocable session; got %d", len(matches))
+	}
+	phoneID := string(matches[0][1])
+
+	tests := []struct {
+		name     string
+		id       string
+		wantCode int
+	}{
+		{"Unknown session", strings.Repeat("0", 64), http.StatusNotFound},
+		{"Other device", phoneID, http.StatusSeeOther},
+		{"Already revoked", phoneID, http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("csrf_token", csrfToken)
+			code, _, _ := laptop.postForm(t, "/user/sessions/"+tt.id+"/revoke", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+		})
+	}
+
+	// 被撤销的设备变成未登录状态，当前设备不受影响
+	code, header, _ := phone.get(t, "/user/sessions")
+	if code != http.StatusFound || header.Get("Location") != "/user/login" {
+		t.Errorf("want revoked device to be logged out; got %d %q", code, header.Get("Location"))
+	}
+	code, _, _ = laptop.get(t, "/user/sessions")
+	if code != http.StatusOK {
+		t.Errorf("want current device to stay logged in; got %d", code)
+	}
+}
+
+// 登出之后服务端不再保留这个用户的会话
+func TestLogoutUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "1207793251@qq.com")
+	if records, _ := app.session.Store.List(1); len(records) != 1 {
+		t.Fatalf("want 1 session after login; got %d", len(records))
+	}
+
+	code, _, _ := ts.postForm(t, "/user/logout", url.Values{"csrf_token": {csrfToken}})
+	if code != http.StatusSeeOther {
+		t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+	}
+	if records, _ := app.session.Store.List(1); len(records) != 0 {
+		t.Errorf("want no sessions after logout; got %d", len(records))
+	}
+
+	_, _, body := ts.get(t, "/")
+	if !bytes.Contains(body, []byte("been logged out successfully")) {
+		t.Error("want logout flash message")
+	}
+}
+
+func TestRevokeOtherSessions(t *testing.T) {
+	tests := []struct {
+		name   string
+		path   string
+		fields url.Values
+	}{
+		{"Log out other devices", "/user/sessions/revokeall", url.Values{}},
+		{"Change password", "/user/resetpassword", url.Values{
+			"current_password": {"validPa$$word"},
+			"new_password":     {"newPa$$word"},
+			"confirm_password": {"newPa$$word"},
+		}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			laptop, phone := newTwoDevices(t)
+			defer laptop.Close()
+			defer phone.Close()
+
+			csrfToken := laptop.login(t, "1207793251@qq.com")
+			phone.login(t, "1207793251@qq.com")
+
+			tt.fields.Set("csrf_token", csrfToken)
+			code, _, _ := laptop.postForm(t, tt.path, tt.fields)
+			if code != http.StatusSeeOther {
+				t.Fatalf("want %d; got %d", http.StatusSeeOther, code)
+			}
+
+			code, _, _ = phone.get(t, "/user/sessions")
+			if code != http.StatusFound {
+				t.Errorf("want other device to be logged out; got %d", code)
+			}
+			code, _, _ = laptop.get(t, "/user/sessions")
+			if code != http.StatusOK {
+				t.Errorf("want current device to stay logged in; got %d", code)
+			}
+		})
+	}
+}
+
+func TestChangePassword(t *testing.T) {
+	app := newTestApplication(t)
+	activity := &mock.ActivityModel{}
+	app.activity = activity
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	csrfToken := ts.login(t, "1207793251@qq.com")
+
+	code, _, body := ts.get(t, "/user/resetpassword")
+	if code != http.StatusOK || !bytes.Contains(body, []byte(`name="current_password"`)) {
+		t.Fatalf("want change password form with current password field; got %d", code)
+	}
+
+	tests := []struct {
+		name            string
+		currentPassword string
+		newPassword     string
+		confirmPassword string
+		wantCode        int
+		wantBody        []byte
+	}{
+		{"Missing current password", "", "newPa$$word", "newPa$$word", http.StatusOK, []byte("This field cannot be blank")},
+		{"Wrong current password", "wrongPa$$word", "newPa$$word", "newPa$$word", http.StatusOK, []byte("当前密码错误")},
+		{"Same as current", "validPa$$word", "validPa$$word", "validPa$$word", http.StatusOK, []byte("must be different")},
+		{"Mismatch", "validPa$$word", "newPa$$word", "otherPa$$word", http.StatusOK, []byte("The values do not match")},
+		{"Valid", "validPa$$word", "newPa$$word", "newPa$$word", http.StatusSeeOther, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			form := url.Values{}
+			form.Add("current_password", tt.currentPassword)
+			form.Add("new_password", tt.newPassword)
+			form.Add("confirm_password", tt.confirmPassword)
+			form.Add("csrf_token", csrfToken)
+
+			code, _, body := ts.postForm(t, "/user/resetpassword", form)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// 只有成功修改的那一次被记录下来
+	events := activity.Events()
+	if len(events) != 1 || events[0] != "1 password_changed" {
+		t.Errorf("want one password_changed event; got %v", events)
+	}
+}
+
+func TestShowUser(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	cursor := (&models.Cursor{Created: time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC), ID: 7}).String()
+
+	tests := []struct {
+		name     string
+		urlPath  string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Valid ID", "/user/1", http.StatusOK, []byte("An old silent pond")},
+		{"Snippet count", "/user/1", http.StatusOK, []byte("共有 1 个snippet")},
+		{"No snippets", "/user/2", http.StatusOK, []byte("nothing to see here")},
+		{"Later page", "/user/1?after=" + cursor, http.StatusOK, []byte("nothing to see here")},
+		{"Invalid cursor", "/user/1?after=foo", http.StatusBadRequest, nil},
+		{"Non-existent ID", "/user/99", http.StatusNotFound, nil},
+		{"String ID", "/user/foo", http.StatusNotFound, nil},
+		{"Login page still routed", "/user/login", http.StatusOK, []byte("<form")},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			code, _, body := ts.get(t, tt.urlPath)
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+		})
+	}
+
+	// 个人主页不能泄露邮箱
+	_, _, body := ts.get(t, "/user/1")
+	if bytes.Contains(body, []byte("1207793251@qq.com")) {
+		t.Error("profile page should not show the user's email")
+	}
+}
+
+func TestUserSnippets(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	code, header, _ := ts.get(t, "/user/snippets")
+	if code != http.StatusFound || header.Get("Location") != "/user/login" {
+		t.Fatalf("want redirect to login; got %d", code)
+	}
+
+	ts.login(t, "1207793251@qq.com")
+
+	// 模拟的snippet已经过期，只出现在自己的列表中，不带链接
+	code, _, body := ts.get(t, "/user/snippets")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if !bytes.Contains(body, []byte(`<td class="expired">An old silent pond</td>`)) {
+		t.Error("want expired snippet to be listed")
+	}
+	// 数量与列表一致，包括已经过期的和私密、阅后即焚等不公开的snippet
+	if !bytes.Contains(body, []byte("共有 6 个snippet")) {
+		t.Error("want count of all owned snippets")
+	}
+}
+
+func TestUpdateSettings(t *testing.T) {
+	tests := []struct {
+		name         string
+		userName     string
+		email        string
+		wantCode     int
+		wantBody     []byte
+		wantMail     []string
+		wantActivity []string
+	}{
+		{"Unchanged", "ltx", "1207793251@qq.com", http.StatusSeeOther, nil, nil, nil},
+		{"Change name", "New Name", "1207793251@qq.com", http.StatusSeeOther, nil, nil, []string{"1 name_changed"}},
+		{"Change email", "ltx", "new@example.com", http.StatusSeeOther, nil, []string{
+			"To: new@example.com",
+			"https://snippetbox.test/user/email/",
+			"To: 1207793251@qq.com",
+		}, nil},
+		{"Duplicate email", "New Name", "alice@example.com", http.StatusOK, []byte("Address is already in use"), nil, nil},
+		{"Invalid email", "ltx", "new@", http.StatusOK, []byte("This field is invalid"), nil, nil},
+		{"Blank name", "", "1207793251@qq.com", http.StatusOK, []byte("This field cannot be blank"), nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			app := newTestApplication(t)
+			var mail bytes.Buffer
+			app.mailer = &mailer.Log{Logger: log.New(&mail, "", 0)}
+			activity := &mock.ActivityModel{}
+			app.activity = activity
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			csrfToken := ts.login(t, "1207793251@qq.com")
+
+			form := url.Values{}
+			form.Add("name", tt.userName)
+			form.Add("email", tt.email)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := ts.postForm(t, "/user/settings", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+			if tt.wantMail == nil && mail.Len() > 0 {
+				t.Errorf("want no mail; got %q", mail.String())
+			}
+			for _, want := range tt.wantMail {
+				if !strings.Contains(mail.String(), want) {
+					t.Errorf("want mail to contain %q; got %q", want, mail.String())
+				}
+			}
+			if got := activity.Events(); strings.Join(got, ",") != strings.Join(tt.wantActivity, ",") {
+				t.Errorf("want activity %v; got %v", tt.wantActivity, got)
+			}
+		})
+	}
+}
+
+func TestConfirmEmailChange(t *testing.T) {
+	ltx := &models.User{ID: 1, Email: "1207793251@qq.com"}
+	hour := time.Now().Add(time.Hour)
+
+	app := newTestApplication(t)
+	valid := app.emailChangeToken(ltx, "new@example.com", hour)
+
+	tests := []struct {
+		name         string
+		token        string
+		wantFlash    string
+		wantActivity []string
+	}{
+		{"Valid", valid, "邮箱修改成功", []string{"1 email_changed"}},
+		{"Tampered signature", valid[:len(valid)-2] + "xx", "确认链接无效", nil},
+		{"Expired", app.emailChangeToken(ltx, "new@example.com", time.Now().Add(-time.Hour)), "确认链接无效", nil},
+		{"Email already changed", app.emailChangeToken(&models.User{ID: 1, Email: "old@example.com"}, "new@example.com", hour), "确认链接无效", nil},
+		{"Verification token", app.verificationToken(ltx, hour), "确认链接无效", nil},
+		{"Taken since", app.emailChangeToken(ltx, "alice@example.com", hour), "已经被其它账号使用", nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			activity := &mock.ActivityModel{}
+			app.activity = activity
+			ts := newTestServer(t, app.routes())
+			defer ts.Close()
+
+			code, header, _ := ts.get(t, "/user/email/"+tt.token)
+			if code != http.StatusSeeOther || header.Get("Location") != "/user/settings" {
+				t.Fatalf("want redirect to settings; got %d %q", code, header.Get("Location"))
+			}
+
+			// 没有登录时设置页面会跳转到登录页面，提示信息在那里展示
+			_, _, body := ts.get(t, "/user/login")
+			if !bytes.Contains(body, []byte(tt.wantFlash)) {
+				t.Errorf("want flash %q", tt.wantFlash)
+			}
+			if got := activity.Events(); strings.Join(got, ",") != strings.Join(tt.wantActivity, ",") {
+				t.Errorf("want activity %v; got %v", tt.wantActivity, got)
+			}
+		})
+	}
+}
+
+func TestExportAccount(t *testing.T) {
+	app := newTestApplication(t)
+	ts := newTestServer(t, app.routes())
+	defer ts.Close()
+
+	ts.login(t, "1207793251@qq.com")
+
+	code, header, body := ts.get(t, "/user/export")
+	if code != http.StatusOK {
+		t.Fatalf("want %d; got %d", http.StatusOK, code)
+	}
+	if ct := header.Get("Content-Type"); ct != "application/zip" {
+		t.Errorf("want application/zip; got %q", ct)
+	}
+	if !strings.HasPrefix(header.Get("Content-Disposition"), "attachment;") {
+		t.Errorf("want attachment; got %q", header.Get("Content-Disposition"))
+	}
+
+	zr, err := zip.NewReader(bytes.NewReader(body), int64(len(body)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{}
+	for _, f := range zr.File {
+		rc, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name] = string(content)
+	}
+
+	tests := []struct {
+		file string
+		want string
+	}{
+		{"profile.json", `"email": "1207793251@qq.com"`},
+		{"snippets/1-an-old-silent-pond.txt", "An old silent pond..."},
+		{"snippets.json", `"file": "snippets/1-an-old-silent-pond.txt"`},
+	}
+	for _, tt := range tests {
+		if !strings.Contains(files[tt.file], tt.want) {
+			t.Errorf("want %s to contain %q; got %q", tt.file, tt.want, files[tt.file])
+		}
+	}
+}
+
+func TestDeleteAccount(t *testing.T) {
+	tests := []struct {
+		name     string
+		password string
+		snippets string
+		wantCode int
+		wantBody []byte
+	}{
+		{"Wrong password", "wrongPa$$word", "delete", http.StatusOK, []byte("密码错误")},
+		{"Missing choice", "validPa$$word", "", http.StatusOK, []byte("This field cannot be blank")},
+		{"Invalid choice", "validPa$$word", "keep", http.StatusOK, []byte("This field is invalid")},
+		{"Delete snippets", "validPa$$word", "delete", http.StatusSeeOther, nil},
+		{"Anonymize snippets", "validPa$$word", "anonymize", http.StatusSeeOther, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			laptop, phone := newTwoDevices(t)
+			defer laptop.Close()
+			defer phone.Close()
+
+			csrfToken := laptop.login(t, "1207793251@qq.com")
+			phone.login(t, "1207793251@qq.com")
+
+			form := url.Values{}
+			form.Add("password", tt.password)
+			form.Add("snippets", tt.snippets)
+			form.Add("csrf_token", csrfToken)
+			code, _, body := laptop.postForm(t, "/user/delete", form)
+
+			if code != tt.wantCode {
+				t.Errorf("want %d; got %d", tt.wantCode, code)
+			}
+			if !bytes.Contains(body, tt.wantBody) {
+				t.Errorf("want body to contain %q", tt.wantBody)
+			}
+
+			// 注销成功之后两台设备都变成未登录状态
+			wantCode := http.StatusOK
+			if tt.wantCode == http.StatusSeeOther {
+				wantCode = http.StatusFound
+			}
+			for _, ts := range []*testServer{laptop, phone} {
+				code, _, _ := ts.get(t, "/user/settings")
+				if code != wantCode {
+					t.Errorf("want settings page %d; got %d", wantCode, code)
+				}
+			}
+		})
+	}
+}
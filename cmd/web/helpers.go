@@ -3,11 +3,14 @@ package main
 import (
 	"bytes"
 	"fmt"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/forms"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
 	"github.com/justinas/nosurf"
 	"net/http"
 	"runtime/debug"
+	"strings"
 	"time"
+	"unicode"
 )
 
 // The serverError helper 写了一个错误信息并且使用栈
@@ -84,3 +87,161 @@ func (app *application) authenticatedUser(r *http.Request) *models.User {
 	}
 	return user
 }
+
+// viewerID 返回当前登录用户的id，没有登录时返回0，用于按可见性查询snippet
+func (app *application) viewerID(r *http.Request) int {
+	if user := app.authenticatedUser(r); user != nil {
+		return user.ID
+	}
+	return 0
+}
+
+// isOwner 返回当前登录用户是否为snippet的作者
+// 匿名化的snippet的UserID与没有登录时的viewerID都是0，不能直接比较
+func (app *application) isOwner(r *http.Request, s *models.Snippet) bool {
+	id := app.viewerID(r)
+	return id != 0 && s.UserID == id
+}
+
+// validateSnippetForm 检验创建和编辑snippet时共同的字段
+func validateSnippetForm(form *forms.Form) {
+	form.Required("title", "content")
+	form.MaxLength("title", 100)
+	form.MaxTags("tags", 5)
+	form.ValidTags("tags")
+	form.PermittedValues("format", models.FormatPlain, models.FormatMarkdown, models.FormatEncrypted)
+	form.PermittedValues("language", languageValues()...)
+	form.PermittedValues("visibility", models.VisibilityPublic, models.VisibilityUnlisted, models.VisibilityPrivate)
+	if form.Get("format") == models.FormatEncrypted {
+		form.ValidCiphertext("content", maxCiphertextBytes)
+	}
+}
+
+// validateFormatChange 检验修改snippet时的格式，加密的snippet和不加密的snippet不能互相转换
+// 转换为加密时旧的明文仍然保存在历史版本中，转换为不加密时服务器也无法解密原来的内容
+func validateFormatChange(form *forms.Form, s *models.Snippet) {
+	if (form.Get("format") == models.FormatEncrypted) != (s.Format == models.FormatEncrypted) {
+		form.Errors.Add("format", "Cannot convert between encrypted and unencrypted snippets")
+	}
+}
+
+// maxCiphertextBytes 是加密内容的最大字节数，base64编码之后仍然可以放进TEXT类型的content列
+const maxCiphertextBytes = 32 * 1024
+
+// fillSnippet 把经过validateSnippetForm检验的字段填入s中
+func fillSnippet(s *models.Snippet, form *forms.Form) {
+	s.Title = form.Get("title")
+	s.Content = form.Get("content")
+	// 没有选择格式时默认为纯文本
+	s.Format = models.FormatPlain
+	switch form.Get("format") {
+	case models.FormatMarkdown:
+		s.Format = models.FormatMarkdown
+	case models.FormatEncrypted:
+		s.Format = models.FormatEncrypted
+	}
+	s.Language = form.Get("language")
+	// 服务器无法高亮加密的内容
+	if s.Format == models.FormatEncrypted {
+		s.Language = ""
+	}
+	// 没有提交可见性时保持原来的设置
+	if v := form.Get("visibility"); v != "" {
+		s.Visibility = v
+	}
+	s.Tags = forms.SplitTags(form.Get("tags"))
+}
+
+// snippetFilename 根据snippet的标题和语言生成下载时使用的文件名
+// 标题中除了字母和数字以外的字符都替换为"-"，标题为空时使用snippet的id
+func snippetFilename(s *models.Snippet) string {
+	name := strings.Map(func(r rune) rune {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			return unicode.ToLower(r)
+		}
+		return '-'
+	}, s.Title)
+	// 合并连续的"-"并去掉两端的"-"
+	name = strings.Join(strings.FieldsFunc(name, func(r rune) bool { return r == '-' }), "-")
+	if name == "" {
+		name = fmt.Sprintf("snippet-%d", s.ID)
+	}
+
+	if s.Format == models.FormatMarkdown {
+		return name + ".md"
+	}
+	return name + languageExtension(s.Language, s.Content)
+}
+
+// pageCursor 从查询参数before或after中解析分页游标，两者都没有时返回nil
+func pageCursor(r *http.Request) (*models.Cursor, error) {
+	if s := r.URL.Query().Get("before"); s != "" {
+		return models.ParseCursor(s, true)
+	}
+	if s := r.URL.Query().Get("after"); s != "" {
+		return models.ParseCursor(s, false)
+	}
+	return nil, nil
+}
+
+// snippetBySlug 根据URL中的:slug取出当前用户可以访问的snippet，包括阅后即焚的snippet
+// 如果出现任何问题，已经向客户端写入了错误响应，返回false
+func (app *application) snippetBySlug(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, err := app.snippets.GetBySlug(r.URL.Query().Get(":slug"), app.viewerID(r))
+	if err == models.ErrNoRecord {
+		app.notFound(w)
+		return nil, false
+	} else if err != nil {
+		app.serverError(w, err)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// snippetFromURL 与snippetBySlug相同，但是阅后即焚的snippet只有作者可以取出
+// 其他人只能在showSnippet中确认之后查看一次，不能通过Raw、下载或者历史版本绕过
+// 有密码的snippet在输入密码之前重定向到snippet页面
+func (app *application) snippetFromURL(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.snippetBySlug(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	if s.BurnAfterReading && !app.isOwner(r, s) {
+		app.notFound(w)
+		return nil, false
+	}
+	if app.snippetLocked(r, s) {
+		http.Redirect(w, r, s.Link(), http.StatusSeeOther)
+		return nil, false
+	}
+
+	return s, true
+}
+
+// unlockedKey 是session中记录已经输入了snippet查看密码的键
+func unlockedKey(id int) string {
+	return fmt.Sprintf("unlockedSnippet.%d", id)
+}
+
+// snippetLocked 返回当前用户是否还需要输入密码才能查看snippet，作者本人不需要密码
+func (app *application) snippetLocked(r *http.Request, s *models.Snippet) bool {
+	return s.Protected && !app.isOwner(r, s) && !app.session.Exists(r, unlockedKey(s.ID))
+}
+
+// ownedSnippet 与snippetFromURL相同，但还要确认当前登录用户就是作者
+func (app *application) ownedSnippet(w http.ResponseWriter, r *http.Request) (*models.Snippet, bool) {
+	s, ok := app.snippetFromURL(w, r)
+	if !ok {
+		return nil, false
+	}
+
+	// 只有作者本人可以修改自己的snippet
+	if !app.isOwner(r, s) {
+		app.clientError(w, http.StatusForbidden)
+		return nil, false
+	}
+
+	return s, true
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	"github.com/alecthomas/chroma/v2/styles"
+	"html"
+	"html/template"
+	"path/filepath"
+	"strings"
+)
+
+// 语言选择中的两个特殊取值：空字符串表示纯文本，auto表示根据内容自动识别
+const (
+	languagePlain = ""
+	languageAuto  = "auto"
+)
+
+// language 表示创建表单中可以选择的一种语言，Value是chroma中lexer的名称
+type language struct {
+	Value string
+	Label string
+}
+
+// languages 是表单中可以选择的语言列表
+var languages = []language{
+	{languagePlain, "Plain text"},
+	{languageAuto, "Auto-detect"},
+	{"bash", "Bash"},
+	{"c", "C"},
+	{"cpp", "C++"},
+	{"csharp", "C#"},
+	{"css", "CSS"},
+	{"diff", "Diff"},
+	{"docker", "Dockerfile"},
+	{"go", "Go"},
+	{"html", "HTML"},
+	{"java", "Java"},
+	{"javascript", "JavaScript"},
+	{"json", "JSON"},
+	{"kotlin", "Kotlin"},
+	{"lua", "Lua"},
+	{"php", "PHP"},
+	{"python", "Python"},
+	{"ruby", "Ruby"},
+	{"rust", "Rust"},
+	{"sql", "SQL"},
+	{"swift", "Swift"},
+	{"toml", "TOML"},
+	{"typescript", "TypeScript"},
+	{"xml", "XML"},
+	{"yaml", "YAML"},
+}
+
+// languageValues 返回所有允许的语言取值，用于表单检验
+func languageValues() []string {
+	values := make([]string, 0, len(languages))
+	for _, l := range languages {
+		values = append(values, l.Value)
+	}
+	return values
+}
+
+// lexerFor 根据snippet选择的语言返回对应的lexer
+// 选择自动识别时根据内容猜测语言，无法识别或者是纯文本时使用纯文本lexer
+func lexerFor(lang, content string) chroma.Lexer {
+	var lexer chroma.Lexer
+	switch lang {
+	case languagePlain:
+	case languageAuto:
+		lexer = lexers.Analyse(content)
+	default:
+		lexer = lexers.Get(lang)
+	}
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	return chroma.Coalesce(lexer)
+}
+
+// languageExtension 返回某种语言的代码文件通常使用的扩展名，例如".go"
+// 纯文本以及无法识别的语言使用".txt"
+func languageExtension(lang, content string) string {
+	config := lexerFor(lang, content).Config()
+	if config == nil {
+		return ".txt"
+	}
+	// lexer的Filenames是类似"*.go"这样的文件名模式
+	for _, pattern := range config.Filenames {
+		ext := filepath.Ext(pattern)
+		if strings.HasPrefix(pattern, "*.") && !strings.ContainsAny(ext, "*?[") {
+			return ext
+		}
+	}
+	return ".txt"
+}
+
+// highlightStyle 是高亮使用的主题，对应的CSS由同一个主题生成并保存在ui/static/css/chroma.css中
+var highlightStyle = styles.Get("github")
+
+// highlightFormatter 输出使用CSS类名的HTML，带有行号，每一行都可以通过#L10这样的锚点链接
+var highlightFormatter = chromahtml.New(
+	chromahtml.WithClasses(true),
+	chromahtml.WithLineNumbers(true),
+	chromahtml.WithLinkableLineNumbers(true, "L"),
+	chromahtml.TabWidth(4),
+)
+
+// highlight 在服务端对代码进行语法高亮，chroma会转义所有的代码内容
+func highlight(content, lang string) template.HTML {
+	iterator, err := lexerFor(lang, content).Tokenise(nil, content)
+	if err != nil {
+		return template.HTML("<pre>" + html.EscapeString(content) + "</pre>")
+	}
+
+	var buf bytes.Buffer
+	err = highlightFormatter.Format(&buf, highlightStyle, iterator)
+	if err != nil {
+		return template.HTML("<pre>" + html.EscapeString(content) + "</pre>")
+	}
+
+	return template.HTML(buf.String())
+}
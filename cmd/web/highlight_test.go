@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		lang     string
+		want     []string
+		dontWant []string
+	}{
+		{
+			name:    "Go",
+			content: "package main\n\nfunc main() {}\n",
+			lang:    "go",
+			want:    []string{`<span class="kn">package</span>`, `id="L3"`, `href="#L3"`},
+		},
+		{
+			name:     "Plain text",
+			content:  "package main",
+			lang:     languagePlain,
+			want:     []string{"package main", `id="L1"`},
+			dontWant: []string{`class="kn"`},
+		},
+		{
+			name:    "Auto-detect",
+			content: "#!/bin/bash\necho hello\n",
+			lang:    languageAuto,
+			want:    []string{`<span class="nb">echo</span>`},
+		},
+		{
+			name:     "Escaped",
+			content:  "<script>alert(1)</script>",
+			lang:     languagePlain,
+			want:     []string{"&lt;script&gt;"},
+			dontWant: []string{"<script>"},
+		},
+		{
+			name:    "Unknown language",
+			content: "hello",
+			lang:    "nope",
+			want:    []string{"hello"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := string(highlight(tt.content, tt.lang))
+			for _, want := range tt.want {
+				if !strings.Contains(got, want) {
+					t.Errorf("want %q to contain %q", got, want)
+				}
+			}
+			for _, dontWant := range tt.dontWant {
+				if strings.Contains(got, dontWant) {
+					t.Errorf("want %q not to contain %q", got, dontWant)
+				}
+			}
+		})
+	}
+}
@@ -4,13 +4,16 @@ import (
 	"crypto/tls"
 	"database/sql"
 	"flag"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/mailer"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models/mysql"
-	"github.com/golangcollege/sessions"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/sessions"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/throttle"
 	"html/template"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql" // 需要它的init函数自动注册驱动到database/sql包中，但是不会调用这个包的任何函数，所以使用_
@@ -21,25 +24,90 @@ type contextKey string
 
 var contextKeyUser = contextKey("user")
 
+// 通过API令牌认证时，令牌的权限范围保存在这个键下
+var contextKeyTokenScope = contextKey("tokenScope")
+
 // 定义一个application结构体去保存依赖，以便在handlers中使用
 type application struct {
 	errorLog *log.Logger
 	infoLog  *log.Logger
 	// 新加一个依赖来自于pkg的数据库操作
 	snippets interface {
-		Insert(string, string, string) (int, error)
-		Get(int) (*models.Snippet, error)
-		Latest() ([]*models.Snippet, error)
-	} // 结构体依赖于这个接口，只要实现了这三个方法的任何类型，
+		Insert(*models.Snippet, string) (int, error)
+		Get(int, int) (*models.Snippet, error)
+		GetBySlug(string, int) (*models.Snippet, error)
+		Update(*models.Snippet) error
+		Delete(int) error
+		Burn(int) error
+		CheckPassword(int, string) error
+		List(*models.Cursor, int) (*models.Page, error)
+		ListByTag(string, *models.Cursor, int) (*models.Page, error)
+		ListByUser(int, *models.Cursor, int) (*models.Page, error)
+		ListOwned(int, *models.Cursor, int) (*models.Page, error)
+		CountByUser(int) (int, error)
+		CountOwned(int) (int, error)
+		Export(int, func(*models.Snippet) error) error
+		TagCloud(int) ([]*models.Tag, error)
+		Search(string, int, int) ([]*models.Snippet, error)
+		Revisions(int) ([]*models.Revision, error)
+		Revision(int, int) (*models.Revision, error)
+	} // 结构体依赖于这个接口，只要实现了这些方法的任何类型，
 	templateCache map[string]*template.Template // 添加依赖来自于html/template包
 	session       *sessions.Session             // 添加session依赖管理状态
+	pageSize      int                           // 分页列表中每页默认显示的snippet数量
 	// 同理
 	users interface {
-		Insert(string, string, string) error
+		Insert(string, string, string) (int, error)
 		Authenticate(string, string) (int, error)
 		Get(int) (*models.User, error)
+		GetByEmail(string) (*models.User, error)
+		CheckPassword(int, string) error
 		UpdatePassword(int, string) error
+		UpdateName(int, string) error
+		UpdateEmail(int, string) error
+		Delete(int, bool) error
+		VerifyEmail(int) error
+	}
+	// 用户创建的API令牌
+	tokens interface {
+		Insert(int, string, string) (string, error)
+		List(int) ([]*models.Token, error)
+		Delete(int, int) error
+		Authenticate(string) (*models.Token, error)
+	}
+	// 找回密码时通过邮件发送的一次性令牌
+	resets interface {
+		Insert(int, time.Duration) (string, error)
+		Check(string) (int, error)
+		Consume(string) (int, error)
+	}
+	// 两步验证的TOTP密钥和恢复码
+	twoFactor interface {
+		Secret(int) (string, bool, error)
+		Begin(int, string) error
+		Enable(int, int64) ([]string, error)
+		Disable(int) error
+		UseStep(int, int64) error
+		UseRecoveryCode(int, string) error
+		RecoveryCodesLeft(int) (int, error)
+	}
+	// 登录失败的限流，分别按邮箱和客户端IP统计，以及登录事件的审计记录
+	emailLimiter *throttle.Limiter
+	ipLimiter    *throttle.Limiter
+	loginEvents  interface {
+		Insert(string, string, string) error
+	}
+	// 输入snippet查看密码失败的限流，按snippet统计
+	snippetLimiter *throttle.Limiter
+	// 账号活动日志，记录修改密码等操作
+	activity interface {
+		Insert(int, string, string) error
 	}
+	mailer  mailer.Mailer
+	baseURL string // 网站的外部地址，用于生成邮件中的链接
+	// 签名邮箱验证链接的密钥，以及是否要求验证邮箱之后才能创建snippet
+	verifyKey       []byte
+	requireVerified bool
 }
 
 func main() {
@@ -53,6 +121,25 @@ func main() {
 	// 定义一个新的命令行标志为了session secret默认值是一个随机的key
 	// 用来封装验证session cookies
 	secret := flag.String("secret", "s6Ndh+nzHbS*+9Pk8qGWhTzbpa@ge", "Secret key")
+
+	// 分页列表中每页显示的snippet数量
+	pageSize := flag.Int("page-size", 10, "Number of snippets per page")
+
+	// 发送邮件使用的SMTP服务器，没有设置时邮件只写入日志
+	baseURL := flag.String("base-url", "https://localhost:4000", "External URL of the site, used in emailed links")
+	smtpAddr := flag.String("smtp-addr", "", "SMTP server address (host:port); emails are logged if empty")
+	smtpUsername := flag.String("smtp-username", "", "SMTP username")
+	smtpPassword := flag.String("smtp-password", "", "SMTP password")
+	smtpFrom := flag.String("smtp-from", "Snippetbox <no-reply@snippetbox.local>", "Sender address for emails")
+
+	// 登录失败记录保存在内存中还是MySQL中，多个进程共享数据库时应该使用mysql
+	throttleStore := flag.String("throttle-store", "memory", "Where to keep failed login counts: memory or mysql")
+
+	// 会话保存在MySQL中还是内存中，保存在内存中时重启之后所有用户都需要重新登录
+	sessionStore := flag.String("session-store", "mysql", "Where to keep sessions: mysql or memory")
+
+	// 开启后，用户需要先验证邮箱才能创建snippet
+	requireVerified := flag.Bool("require-verified-email", false, "Block snippet creation until the user's email address is verified")
 	// 扫描命令行参数根据预定义的标志解析参数
 	flag.Parse()
 
@@ -76,21 +163,61 @@ func main() {
 		errorLog.Fatal(err)
 	}
 
-	// 初始化一个新的session manager,传入密钥当做参数
+	// 初始化一个新的session manager，会话数据保存在服务端，cookie中只有会话令牌
 	// 返回一个session结构体包括了会话的配置信息
 	// 比如生命周期，设置12个小时的过期时间
-	session := sessions.New([]byte(*secret))
+	var ss sessions.Store
+	switch *sessionStore {
+	case "mysql":
+		ss = &mysql.SessionModel{DB: db}
+	case "memory":
+		ss = sessions.NewMemoryStore()
+	default:
+		errorLog.Fatalf("unknown session store %q", *sessionStore)
+	}
+	session := sessions.New(ss)
 	session.Lifetime = 12 * time.Hour
-	session.Secure = true // 设置安全标志
+	session.Secure = true      // 设置安全标志
+	session.UserKey = "userID" // 记录会话属于哪个用户，以便列出和撤销
+	// 定期清理过期的会话，而不是在每次保存会话时清理
+	go session.Cleanup(time.Hour, nil, errorLog)
+
+	var store throttle.Store
+	switch *throttleStore {
+	case "memory":
+		store = throttle.NewMemoryStore(time.Hour)
+	case "mysql":
+		store = &mysql.LoginFailureModel{DB: db}
+	default:
+		errorLog.Fatalf("unknown throttle store %q", *throttleStore)
+	}
+
+	var m mailer.Mailer = &mailer.Log{Logger: infoLog}
+	if *smtpAddr != "" {
+		m = &mailer.SMTP{Addr: *smtpAddr, Username: *smtpUsername, Password: *smtpPassword, From: *smtpFrom}
+	}
 
 	// 初始化一个新的application实例包括这些依赖
 	app := &application{
-		errorLog:      errorLog,
-		infoLog:       infoLog,
-		snippets:      &mysql.SnippetModel{DB: db},
-		templateCache: templateCache,
-		session:       session,
-		users:         &mysql.UserModel{DB: db},
+		errorLog:        errorLog,
+		infoLog:         infoLog,
+		snippets:        &mysql.SnippetModel{DB: db},
+		templateCache:   templateCache,
+		session:         session,
+		pageSize:        *pageSize,
+		users:           &mysql.UserModel{DB: db},
+		tokens:          &mysql.TokenModel{DB: db},
+		resets:          &mysql.PasswordResetModel{DB: db},
+		twoFactor:       &mysql.TwoFactorModel{DB: db},
+		emailLimiter:    throttle.New(store, emailPolicy),
+		ipLimiter:       throttle.New(store, ipPolicy),
+		snippetLimiter:  throttle.New(store, snippetPolicy),
+		loginEvents:     &mysql.LoginEventModel{DB: db},
+		activity:        &mysql.ActivityModel{DB: db},
+		mailer:          m,
+		baseURL:         strings.TrimSuffix(*baseURL, "/"),
+		verifyKey:       verificationKey(*secret),
+		requireVerified: *requireVerified,
 	}
 
 	// 初始化一个tls.Config结构体去保存我们想要服务器使用的TLS设置
@@ -108,6 +235,7 @@ func main() {
 		Handler:   app.routes(),
 		TLSConfig: tlsConfig,
 		// Add Idle, Read and Write timeouts to the server
+		// 导出个人数据时每写一个文件会延长一次写入期限，见exportFlusher
 		IdleTimeout:  time.Minute,
 		ReadTimeout:  5 * time.Second,
 		WriteTimeout: 10 * time.Second,
@@ -6,6 +6,7 @@ import (
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
 	"github.com/justinas/nosurf"
 	"net/http"
+	"strings"
 )
 
 // 添加HTTP响应的必要头部信息
@@ -74,6 +75,20 @@ func (app *application) requireAuthenticatedUser(next http.Handler) http.Handler
 
 }
 
+// 开启了-require-verified-email选项时，还没有验证邮箱的用户会被重定向到验证页面
+// 需要放在requireAuthenticatedUser之后
+func (app *application) requireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.requireVerified && !app.authenticatedUser(r).EmailVerified {
+			app.session.Put(r, "flash", "请先验证你的邮箱")
+			http.Redirect(w, r, "/user/verify", http.StatusSeeOther)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
 // 检查当前用户session中的userID是否正确，如果正确将用户信息放入到请求上下文中更新请求
 func (app *application) authenticate(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -101,3 +116,63 @@ func (app *application) authenticate(next http.Handler) http.Handler {
 		next.ServeHTTP(w, r.WithContext(ctx)) // r = r.WithContext(ctx)
 	})
 }
+
+// authenticateToken 检查Authorization: Bearer请求头中的API令牌
+// 令牌有效时与authenticate一样把用户信息放入请求上下文，同时记录令牌的权限范围
+// 没有提供令牌时继续处理链，令牌无效时返回401
+func (app *application) authenticateToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := r.Header.Get("Authorization")
+		if !strings.HasPrefix(header, "Bearer ") {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		token, err := app.tokens.Authenticate(strings.TrimSpace(strings.TrimPrefix(header, "Bearer ")))
+		if err == models.ErrInvalidCredentials {
+			app.apiUnauthorized(w, "Invalid token")
+			return
+		} else if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+
+		user, err := app.users.Get(token.UserID)
+		if err == models.ErrNoRecord {
+			app.apiUnauthorized(w, "Invalid token")
+			return
+		} else if err != nil {
+			app.apiServerError(w, err)
+			return
+		}
+
+		ctx := context.WithValue(r.Context(), contextKeyUser, user)
+		ctx = context.WithValue(ctx, contextKeyTokenScope, token.Scope)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// requireWriteScope 拒绝只有读权限的API令牌，通过其它方式认证的用户不受限制
+func (app *application) requireWriteScope(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, ok := r.Context().Value(contextKeyTokenScope).(string)
+		if ok && scope != models.ScopeWrite {
+			app.apiError(w, http.StatusForbidden, "Token does not have write scope")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// apiRequireVerifiedEmail 与requireVerifiedEmail相同，但是返回403
+func (app *application) apiRequireVerifiedEmail(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if app.requireVerified && !app.authenticatedUser(r).EmailVerified {
+			app.apiError(w, http.StatusForbidden, "Email address not verified")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
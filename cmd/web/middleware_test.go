@@ -17,7 +17,7 @@ func TestSecureHeaders(t *testing.T) {
 
 	// 创建了一个模拟的HTTP处理器，将其传给中间件
 	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		w.Write([]byte("Ok"))
+		w.Write([]byte("OK"))
 	})
 
 	// 传给要测试的中间件
@@ -15,33 +15,114 @@ func (app *application) routes() http.Handler {
 	// 将nosurf中间件作用域我们所有的动态路由上
 	dynamicMiddleware := alice.New(app.session.Enable, noSurf, app.authenticate)
 
+	// API使用单独的处理链，不使用session和CSRF防护，用户通过请求头中的API令牌或者HTTP Basic认证识别
+	apiMiddleware := alice.New(app.authenticateToken, app.apiAuthenticate)
+	// 修改数据的API还需要令牌有写权限
+	apiWriteMiddleware := apiMiddleware.Append(app.requireAPIUser, app.requireWriteScope)
+
 	// mux也实现了ServeHTTP()方法，是一个请求路由器和多路复用器
 	// 这里引入第三方路由框架
 	mux := pat.New()
 	// 使用动态处理中间件链来解决这些路由问题
 	// 最终实现了转为handler注册为路由
 	mux.Get("/", dynamicMiddleware.ThenFunc(app.home))
+	mux.Get("/snippets", dynamicMiddleware.ThenFunc(app.browseSnippets))
+	mux.Get("/search", dynamicMiddleware.ThenFunc(app.search))
+	mux.Get("/tag/:name", dynamicMiddleware.ThenFunc(app.showTag))
 	// Append增加requireAuthenticatedUser中间件来保护create路由
 	// 防止未登录的用户进行创建操作
-	mux.Get("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createSnippetForm))
-	mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createSnippet))
-	mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.showSnippet))
+	// 根据配置还可能要求用户先验证邮箱
+	mux.Get("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser, app.requireVerifiedEmail).ThenFunc(app.createSnippetForm))
+	mux.Post("/snippet/create", dynamicMiddleware.Append(app.requireAuthenticatedUser, app.requireVerifiedEmail).ThenFunc(app.createSnippet))
+	// snippet的地址使用随机的slug，不会暴露自增的id
+	mux.Get("/s/:slug", dynamicMiddleware.ThenFunc(app.showSnippet))
+	// 阅后即焚的snippet需要提交确认表单才能查看，链接预览等只发送GET请求的程序不会删除它
+	mux.Post("/s/:slug", dynamicMiddleware.ThenFunc(app.burnSnippet))
+	// 输入有密码的snippet的查看密码
+	mux.Post("/s/:slug/unlock", dynamicMiddleware.ThenFunc(app.unlockSnippet))
+	// 直接获取snippet内容，方便在脚本中使用
+	mux.Get("/s/:slug/raw", dynamicMiddleware.ThenFunc(app.rawSnippet))
+	mux.Get("/s/:slug/download", dynamicMiddleware.ThenFunc(app.downloadSnippet))
+	// 浏览器加密的snippet的密文，供命令行等客户端自己解密
+	mux.Get("/s/:slug/ciphertext", dynamicMiddleware.ThenFunc(app.ciphertextSnippet))
+	// 编辑和删除只对登录用户开放，是否为作者在处理器中检查
+	mux.Get("/s/:slug/edit", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.editSnippetForm))
+	mux.Post("/s/:slug/edit", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.editSnippet))
+	mux.Post("/s/:slug/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteSnippet))
+	// 历史版本和版本之间的比较，恢复版本同样只有作者可以操作
+	mux.Get("/s/:slug/history", dynamicMiddleware.ThenFunc(app.snippetHistory))
+	mux.Get("/s/:slug/history/:rev", dynamicMiddleware.ThenFunc(app.showRevision))
+	mux.Post("/s/:slug/history/:rev/restore", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.restoreRevision))
+	mux.Get("/s/:slug/diff", dynamicMiddleware.ThenFunc(app.snippetDiff))
+	// 以前使用数字id的地址重定向到slug地址
+	mux.Get("/snippet/:id", dynamicMiddleware.ThenFunc(app.redirectSnippetID))
+	mux.Get("/snippet/:id/raw", dynamicMiddleware.ThenFunc(app.redirectSnippetID))
+	mux.Get("/snippet/:id/download", dynamicMiddleware.ThenFunc(app.redirectSnippetID))
+	mux.Get("/snippet/:id/edit", dynamicMiddleware.ThenFunc(app.redirectSnippetID))
+	mux.Get("/snippet/:id/history", dynamicMiddleware.ThenFunc(app.redirectSnippetID))
+	mux.Get("/snippet/:id/history/:rev", dynamicMiddleware.ThenFunc(app.redirectSnippetID))
+	mux.Get("/snippet/:id/diff", dynamicMiddleware.ThenFunc(app.redirectSnippetID))
 
 	// 添加5个新的用户路由
 	mux.Get("/user/signup", dynamicMiddleware.ThenFunc(app.signupUserForm))
 	mux.Post("/user/signup", dynamicMiddleware.ThenFunc(app.signupUser))
 	mux.Get("/user/login", dynamicMiddleware.ThenFunc(app.loginUserForm))
 	mux.Post("/user/login", dynamicMiddleware.ThenFunc(app.loginUser))
+	// 开启了两步验证的用户输入密码之后还需要输入验证码
+	mux.Get("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactorForm))
+	mux.Post("/user/login/2fa", dynamicMiddleware.ThenFunc(app.loginTwoFactor))
 	// 同理添加中间件保护路由
 	mux.Post("/user/logout", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.logoutUser))
 	// 添加重置密码的处理路由
 	mux.Get("/user/resetpassword", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.resetPasswordForm))
 	mux.Post("/user/resetpassword", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.resetPassword))
+	// 验证邮箱，验证链接本身不需要登录
+	mux.Get("/user/verify", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.verifyEmailPage))
+	mux.Post("/user/verify", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.resendVerification))
+	mux.Get("/user/verify/:token", dynamicMiddleware.ThenFunc(app.verifyEmail))
+	// 忘记密码时通过邮件中的一次性链接重置密码，不需要登录
+	mux.Get("/user/forgotpassword", dynamicMiddleware.ThenFunc(app.forgotPasswordForm))
+	mux.Post("/user/forgotpassword", dynamicMiddleware.ThenFunc(app.forgotPassword))
+	mux.Get("/user/resetpassword/:token", dynamicMiddleware.ThenFunc(app.recoverPasswordForm))
+	mux.Post("/user/resetpassword/:token", dynamicMiddleware.ThenFunc(app.recoverPassword))
+	// 设置两步验证
+	mux.Get("/user/2fa", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.twoFactorPage))
+	mux.Post("/user/2fa/setup", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.beginTwoFactor))
+	mux.Get("/user/2fa/setup", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.twoFactorSetupPage))
+	mux.Post("/user/2fa/enable", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.enableTwoFactor))
+	mux.Post("/user/2fa/disable", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.disableTwoFactor))
+	// 管理API令牌
+	mux.Get("/user/tokens", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.tokensPage))
+	mux.Post("/user/tokens", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.createToken))
+	mux.Post("/user/tokens/:id/revoke", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.revokeToken))
+	// 管理登录中的会话，可以退出其它设备
+	mux.Get("/user/sessions", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.sessionsPage))
+	mux.Post("/user/sessions/revokeall", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.revokeOtherSessions))
+	mux.Post("/user/sessions/:id/revoke", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.revokeSession))
+	// 账号设置，修改邮箱需要通过发送到新邮箱的链接确认
+	mux.Get("/user/settings", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.settingsForm))
+	mux.Post("/user/settings", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.updateSettings))
+	mux.Get("/user/email/:token", dynamicMiddleware.ThenFunc(app.confirmEmailChange))
+	// 导出个人数据以及注销账号
+	mux.Get("/user/export", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.exportAccount))
+	mux.Get("/user/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteAccountForm))
+	mux.Post("/user/delete", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.deleteAccount))
+	// 当前用户自己的snippet，包括已经过期的
+	mux.Get("/user/snippets", dynamicMiddleware.Append(app.requireAuthenticatedUser).ThenFunc(app.userSnippets))
+	// 用户的个人主页，必须注册在其它/user/开头的GET路由之后
+	mux.Get("/user/:id", dynamicMiddleware.ThenFunc(app.showUser))
 	// 添加处理函数为了About界面
 	mux.Get("/about", dynamicMiddleware.ThenFunc(app.about))
 	// 注册ping处理器为了测试用
 	mux.Get("/ping", http.HandlerFunc(ping))
 
+	// JSON API，读取不需要认证，修改需要认证并且只有作者可以修改
+	mux.Get("/api/v1/snippets", apiMiddleware.ThenFunc(app.apiListSnippets))
+	mux.Post("/api/v1/snippets", apiWriteMiddleware.Append(app.apiRequireVerifiedEmail).ThenFunc(app.apiCreateSnippet))
+	mux.Get("/api/v1/snippets/:slug", apiMiddleware.ThenFunc(app.apiShowSnippet))
+	mux.Put("/api/v1/snippets/:slug", apiWriteMiddleware.ThenFunc(app.apiUpdateSnippet))
+	mux.Del("/api/v1/snippets/:slug", apiWriteMiddleware.ThenFunc(app.apiDeleteSnippet))
+
 	// fileServer创建一个用于提供静态文件的HTTP文件服务器
 	// 将./ui/static目录作为静态文件的根目录，处理对该目录中文件的请求
 	fileServer := http.FileServer(http.Dir("./ui/static"))
@@ -1,11 +1,21 @@
 package main
 
 import (
+	"bytes"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/diff"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/forms"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/sessions"
+	"github.com/microcosm-cc/bluemonday"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/extension"
+	"html"
 	"html/template"
 	"path/filepath"
+	"regexp"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 // 定义一个templateData type 来包裹任何动态数据结构（用来传给HTML）
@@ -14,10 +24,34 @@ type templateData struct {
 	CurrentYear       int // 用来存放一般动态数据
 	Snippet           *models.Snippet
 	Snippets          []*models.Snippet
+	Page              *models.Page // 分页列表的当前页，包含上一页和下一页的游标
+	Limit             int          // 分页列表中每页的数量
+	PageNumber        int          // 按页码分页时的当前页码，从1开始
+	HasMore           bool         // 按页码分页时是否还有下一页
+	Tag               string       // 标签页面当前的标签
+	Tags              []*models.Tag
 	Form              *forms.Form  // 引入表单字段（包括具体字段值和错误信息）
 	Flash             string       // 临时消息存储机制
 	AuthenticatedUser *models.User // 之前通过id判断当前用户是否已经登录，现在通过上下文中包含的用户对象
 	CSRFToken         string       // 表示模版中的CSRFToken属性，使每个表单都有一个CSRF令牌
+	Revision          *models.Revision
+	Revisions         []*models.Revision
+	DiffFrom          *models.Revision // 比较两个版本时的旧版本和新版本
+	DiffTo            *models.Revision
+	Diff              []diff.Hunk
+	Tokens            []*models.Token
+	NewToken          string // 刚创建的API令牌明文，只展示这一次
+	ResetToken        string // 通过邮件中的链接重置密码时使用的令牌
+	TwoFactorEnabled  bool
+	TOTPSecret        string       // 设置两步验证时展示的密钥，无法扫描二维码时可以手动输入
+	QRCode            template.URL // 密钥二维码的PNG图片，data URL格式
+	RecoveryCodes     []string     // 刚生成的恢复码，只展示这一次
+	RecoveryCodesLeft int
+	Sessions          []*sessions.Record
+	SessionID         string       // 当前请求的会话ID，用于在会话列表中标出当前设备
+	Profile           *models.User // 个人主页展示的用户
+	SnippetCount      int          // 用户的snippet数量，个人主页上只计算公开的未过期的snippet
+	Burned            bool         // 阅后即焚的snippet已经在这次查看时删除
 }
 
 // 自定义函数humanDate
@@ -30,11 +64,112 @@ func humanDate(t time.Time) string {
 	return t.UTC().Format("02 Jan 2006 at 15:04")
 }
 
+// excerpt 从text中截取第一个匹配query中任意关键词附近的一段文字
+// 所有匹配的关键词使用<mark>标记，其余文字都经过HTML转义，所以可以安全地作为template.HTML返回
+func excerpt(text, query string, length int) template.HTML {
+	// 将换行和连续的空白合并为一个空格
+	text = strings.Join(strings.Fields(text), " ")
+
+	terms := []string{}
+	for _, term := range strings.Fields(query) {
+		terms = append(terms, regexp.QuoteMeta(term))
+	}
+
+	var rx *regexp.Regexp
+	if len(terms) > 0 {
+		rx = regexp.MustCompile("(?i)" + strings.Join(terms, "|"))
+	}
+
+	// 以第一个匹配位置之前的一小段文字作为摘要的开头
+	runes := []rune(text)
+	start := 0
+	if rx != nil {
+		if loc := rx.FindStringIndex(text); loc != nil {
+			start = utf8.RuneCountInString(text[:loc[0]]) - length/4
+			if start < 0 {
+				start = 0
+			}
+		}
+	}
+	end := start + length
+	if end > len(runes) {
+		end = len(runes)
+	}
+	part := string(runes[start:end])
+
+	var b strings.Builder
+	if start > 0 {
+		b.WriteString("…")
+	}
+	last := 0
+	if rx != nil {
+		for _, loc := range rx.FindAllStringIndex(part, -1) {
+			b.WriteString(html.EscapeString(part[last:loc[0]]))
+			b.WriteString("<mark>" + html.EscapeString(part[loc[0]:loc[1]]) + "</mark>")
+			last = loc[1]
+		}
+	}
+	b.WriteString(html.EscapeString(part[last:]))
+	if end < len(runes) {
+		b.WriteString("…")
+	}
+
+	return template.HTML(b.String())
+}
+
+// tagSize 根据标签的使用次数计算它在标签云中的字体大小（像素），使用最多的标签最大
+func tagSize(tag *models.Tag, tags []*models.Tag) int {
+	max := 1
+	for _, t := range tags {
+		if t.Count > max {
+			max = t.Count
+		}
+	}
+	return 14 + 14*(tag.Count-1)/max
+}
+
+// visibilityLabel 返回snippet可见性在页面中显示的名称
+func visibilityLabel(visibility string) string {
+	switch visibility {
+	case models.VisibilityUnlisted:
+		return "不公开"
+	case models.VisibilityPrivate:
+		return "私密"
+	default:
+		return "公开"
+	}
+}
+
+// markdownRenderer 支持GitHub风格的表格、删除线和自动链接
+// 默认配置下goldmark不会输出markdown中的原始HTML
+var markdownRenderer = goldmark.New(goldmark.WithExtensions(extension.GFM))
+
+// markdownPolicy 只保留用户内容中常见的安全标签和属性，去掉脚本、事件属性和javascript:链接
+var markdownPolicy = bluemonday.UGCPolicy()
+
+// markdown 在服务端把markdown渲染为HTML，再经过markdownPolicy清理
+// 返回的template.HTML不会再被html/template转义，所以防止脚本注入完全依赖这里的清理
+func markdown(content string) template.HTML {
+	var buf bytes.Buffer
+	if err := markdownRenderer.Convert([]byte(content), &buf); err != nil {
+		// 渲染失败时退回到转义后的纯文本
+		return template.HTML("<pre>" + html.EscapeString(content) + "</pre>")
+	}
+	return template.HTML(markdownPolicy.SanitizeBytes(buf.Bytes()))
+}
+
 // FuncMap将自定义函数注册到模版中
 // map键为模版中使用时的名称，值是实际的go函数
 // 将humanDate函数映射为humanDate这个名称，可以在模版中使用{{huamnDate .Timestamp}}调用
 var functions = template.FuncMap{
-	"humanDate": humanDate,
+	"humanDate":  humanDate,
+	"excerpt":    excerpt,
+	"add":        func(a, b int) int { return a + b },
+	"tagSize":    tagSize,
+	"visibility": visibilityLabel,
+	"markdown":   markdown,
+	"highlight":  highlight,
+	"languages":  func() []language { return languages },
 }
 
 // 添加缓存方法
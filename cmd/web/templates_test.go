@@ -1,6 +1,7 @@
 package main
 
 import (
+	"html/template"
 	"testing"
 	"time"
 )
@@ -41,3 +42,108 @@ func TestHumanDate(t *testing.T) {
 		})
 	}
 }
+
+func TestExcerpt(t *testing.T) {
+	tests := []struct {
+		name   string
+		text   string
+		query  string
+		length int
+		want   template.HTML
+	}{
+		{
+			name:   "Highlight",
+			text:   "An old silent pond",
+			query:  "pond",
+			length: 100,
+			want:   "An old silent <mark>pond</mark>",
+		},
+		{
+			name:   "Case insensitive and multiple terms",
+			text:   "Pond, frog and pond",
+			query:  "pond FROG",
+			length: 100,
+			want:   "<mark>Pond</mark>, <mark>frog</mark> and <mark>pond</mark>",
+		},
+		{
+			name:   "Escaped",
+			text:   "<script>alert('pond')</script>",
+			query:  "pond",
+			length: 100,
+			want:   "&lt;script&gt;alert(&#39;<mark>pond</mark>&#39;)&lt;/script&gt;",
+		},
+		{
+			name:   "Window around match",
+			text:   "aaaa bbbb cccc dddd pond eeee ffff",
+			query:  "pond",
+			length: 12,
+			want:   "…dd <mark>pond</mark> eeee…",
+		},
+		{
+			name:   "No match",
+			text:   "An old\nsilent pond",
+			query:  "frog",
+			length: 10,
+			want:   "An old sil…",
+		},
+		{
+			name:   "Multibyte",
+			text:   "古池や蛙飛び込む水の音",
+			query:  "蛙",
+			length: 4,
+			want:   "…や<mark>蛙</mark>飛び…",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := excerpt(tt.text, tt.query, tt.length)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestMarkdown(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want template.HTML
+	}{
+		{
+			name: "Heading and list",
+			text: "# Title\n\n- one\n- two",
+			want: "<h1>Title</h1>\n<ul>\n<li>one</li>\n<li>two</li>\n</ul>\n",
+		},
+		{
+			name: "Link",
+			text: "[Go](https://golang.org/)",
+			want: "<p><a href=\"https://golang.org/\" rel=\"nofollow\">Go</a></p>\n",
+		},
+		{
+			name: "Raw script",
+			text: "<script>alert(1)</script>",
+			want: "\n",
+		},
+		{
+			name: "Inline event handler",
+			text: "<img src=x onerror=alert(1)>",
+			want: "\n",
+		},
+		{
+			name: "Javascript link",
+			text: "[click](javascript:alert(1))",
+			want: "<p>click</p>\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := markdown(tt.text)
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
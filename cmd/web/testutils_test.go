@@ -1,8 +1,11 @@
 package main
 
 import (
+	"bytes"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/mailer"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models/mock"
-	"github.com/golangcollege/sessions"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/sessions"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/throttle"
 	"html"
 	"io/ioutil"
 	"log"
@@ -16,7 +19,7 @@ import (
 )
 
 // 定义一个表达式捕获CSRF token value from the HTML for user signup page
-var csrfTokenRX = regexp.MustCompile(`<input type='hidden' name='csrf_token' value='{{.CSRFToken}}'`)
+var csrfTokenRX = regexp.MustCompile(`<input type="hidden" name="csrf_token" value="(.+?)">`)
 
 // 从HTML body中提取token
 func extractCSRFToken(t *testing.T, body []byte) string {
@@ -39,18 +42,31 @@ func newTestApplication(t *testing.T) *application {
 	}
 
 	// 创建一个会话管理实例，设置与生产环境相同
-	session := sessions.New([]byte("s6Ndh+nzHbS*+9Pk8qGWhTzbpa@ge"))
+	session := sessions.New(sessions.NewMemoryStore())
 	session.Lifetime = 12 * time.Hour
 	session.Secure = true
+	session.UserKey = "userID"
 
 	// 初始化依赖使用模仿的loggers和database models
 	return &application{
-		errorLog:      log.New(ioutil.Discard, "", 0),
-		infoLog:       log.New(ioutil.Discard, "", 0),
-		session:       session,
-		snippets:      &mock.SnippetModel{},
-		templateCache: templateCache,
-		users:         &mock.UserModel{},
+		errorLog:       log.New(ioutil.Discard, "", 0),
+		infoLog:        log.New(ioutil.Discard, "", 0),
+		session:        session,
+		pageSize:       10,
+		snippets:       &mock.SnippetModel{},
+		templateCache:  templateCache,
+		users:          &mock.UserModel{},
+		tokens:         &mock.TokenModel{},
+		resets:         &mock.PasswordResetModel{},
+		twoFactor:      &mock.TwoFactorModel{},
+		emailLimiter:   throttle.New(throttle.NewMemoryStore(time.Hour), emailPolicy),
+		ipLimiter:      throttle.New(throttle.NewMemoryStore(time.Hour), ipPolicy),
+		snippetLimiter: throttle.New(throttle.NewMemoryStore(time.Hour), snippetPolicy),
+		loginEvents:    &mock.LoginEventModel{},
+		activity:       &mock.ActivityModel{},
+		mailer:         &mailer.Log{Logger: log.New(ioutil.Discard, "", 0)},
+		baseURL:        "https://snippetbox.test",
+		verifyKey:      verificationKey("s6Ndh+nzHbS*+9Pk8qGWhTzbpa@ge"),
 	}
 }
 
@@ -115,3 +131,63 @@ func (ts *testServer) postForm(t *testing.T, urlPath string, form url.Values) (i
 	// Return the response status, headers and body
 	return rs.StatusCode, rs.Header, body
 }
+
+// 使用给定的邮箱登录测试服务器，登录后的session cookie保存在客户端的cookie jar中
+// 返回登录后页面上可用的CSRF令牌
+func (ts *testServer) login(t *testing.T, email string) string {
+	_, _, body := ts.get(t, "/user/login")
+	csrfToken := extractCSRFToken(t, body)
+
+	form := url.Values{}
+	form.Add("email", email)
+	form.Add("password", "validPa$$word")
+	form.Add("csrf_token", csrfToken)
+	code, _, _ := ts.postForm(t, "/user/login", form)
+	if code != http.StatusSeeOther {
+		t.Fatalf("login as %s failed with status %d", email, code)
+	}
+
+	return csrfToken
+}
+
+// 发送一个API请求，header中一般是认证信息，body不为空时作为JSON请求体
+func (ts *testServer) apiRequest(t *testing.T, method, urlPath string, header http.Header, body string) (int, http.Header, []byte) {
+	req, err := http.NewRequest(method, ts.URL+urlPath, bytes.NewBufferString(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if body != "" {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	rs, err := ts.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	defer rs.Body.Close()
+	rsBody, err := ioutil.ReadAll(rs.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return rs.StatusCode, rs.Header, rsBody
+}
+
+// basicAuth 返回使用给定邮箱和测试密码进行HTTP Basic认证的请求头，email为空时不认证
+func basicAuth(email string) http.Header {
+	if email == "" {
+		return nil
+	}
+	req := &http.Request{Header: http.Header{}}
+	req.SetBasicAuth(email, "validPa$$word")
+	return req.Header
+}
+
+// bearerAuth 返回使用API令牌认证的请求头
+func bearerAuth(token string) http.Header {
+	return http.Header{"Authorization": {"Bearer " + token}}
+}
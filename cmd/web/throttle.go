@@ -0,0 +1,121 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/forms"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/throttle"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// 同一个邮箱连续失败5次之后需要等待，等待时间从2秒开始翻倍，最长锁定15分钟
+var emailPolicy = throttle.Policy{Free: 5, Base: 2 * time.Second, Max: 15 * time.Minute, Forget: time.Hour}
+
+// 同一个IP可能有很多用户（例如公司的出口IP），所以允许更多的失败次数
+var ipPolicy = throttle.Policy{Free: 20, Base: time.Second, Max: 15 * time.Minute, Forget: time.Hour}
+
+// 猜测snippet的查看密码时，不管来自哪个IP都按snippet统计
+var snippetPolicy = throttle.Policy{Free: 5, Base: 2 * time.Second, Max: 15 * time.Minute, Forget: time.Hour}
+
+// 登录审计中记录的事件
+const (
+	loginSuccess        = "success"
+	loginFailure        = "failure"
+	loginLocked         = "locked"
+	loginTwoFactorFails = "2fa_failure"
+)
+
+// clientIP 返回客户端的IP，没有信任X-Forwarded-For，因为它可以被客户端伪造
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// emailKey 使用邮箱的哈希值，登录表单中的邮箱没有长度限制，不能直接放进throttle_key列
+func emailKey(email string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+	return "email:" + hex.EncodeToString(sum[:])
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+func snippetKey(id int) string {
+	return "snippet:" + strconv.Itoa(id)
+}
+
+// loginAttempt 在检查密码或者验证码之前把这次登录同时计入邮箱和IP的失败次数，
+// 返回还需要等待多久才能再次尝试登录，取两者中较长的一个，0表示可以继续检查
+// 先计数再检查，同时到达的请求不能都通过限制，检查失败时不需要再记录
+func (app *application) loginAttempt(email, ip string) (time.Duration, error) {
+	emailWait, err := app.emailLimiter.Attempt(emailKey(email))
+	if err != nil {
+		return 0, err
+	}
+	ipWait, err := app.ipLimiter.Attempt(ipKey(ip))
+	if err != nil {
+		return 0, err
+	}
+
+	if ipWait > emailWait {
+		return ipWait, nil
+	}
+	return emailWait, nil
+}
+
+// loginPassed 撤销这次尝试计入的失败次数，用于密码正确但还需要两步验证的情况，
+// 之前的失败记录不清除，因为知道密码的人仍然要受验证码失败次数的限制
+func (app *application) loginPassed(email, ip string) error {
+	if err := app.emailLimiter.Refund(emailKey(email)); err != nil {
+		return err
+	}
+	return app.ipLimiter.Refund(ipKey(ip))
+}
+
+// loginSucceeded 清除邮箱的失败记录，IP只撤销这次尝试计入的次数，
+// 否则攻击者可以用自己的账号登录来重置IP的失败次数
+func (app *application) loginSucceeded(email, ip string) error {
+	if err := app.emailLimiter.Reset(emailKey(email)); err != nil {
+		return err
+	}
+	return app.ipLimiter.Refund(ipKey(ip))
+}
+
+// auditLogin 记录一次登录事件，记录失败不影响登录本身，所以只写入错误日志
+func (app *application) auditLogin(email, ip, event string) {
+	err := app.loginEvents.Insert(strings.ToLower(email), ip, event)
+	if err != nil {
+		app.errorLog.Print(err)
+	}
+}
+
+// waitMessage 把等待时间转换为提示信息，不足一分钟时以秒为单位
+func waitMessage(wait time.Duration) string {
+	if wait < time.Minute {
+		return fmt.Sprintf("%d秒", int(math.Ceil(wait.Seconds())))
+	}
+	return fmt.Sprintf("%d分钟", int(math.Ceil(wait.Minutes())))
+}
+
+// tooManyAttempts 以429状态码重新展示表单，并告诉用户需要等待多久
+func (app *application) tooManyAttempts(w http.ResponseWriter, r *http.Request, name string, form *forms.Form, wait time.Duration) {
+	form.Errors.Add("generic", fmt.Sprintf("登录失败次数过多，账号已被临时锁定，请在%s后重试", waitMessage(wait)))
+	retryAfter(w, wait)
+	app.render(w, r, name, &templateData{Form: form})
+}
+
+// retryAfter 写入429状态码和Retry-After头，之后仍然可以渲染页面
+func retryAfter(w http.ResponseWriter, wait time.Duration) {
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests)
+}
@@ -0,0 +1,144 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// emailVerificationTTL 是验证邮件中链接的有效期
+const emailVerificationTTL = 24 * time.Hour
+
+// verificationKey 从session密钥派生出签名验证链接使用的密钥，两者不会互相影响
+func verificationKey(secret string) []byte {
+	sum := sha256.Sum256([]byte("email-verification:" + secret))
+	return sum[:]
+}
+
+// verificationToken 生成邮箱验证令牌，形如"<用户id>.<过期时间>.<签名>"
+// 签名同时覆盖了用户当前的邮箱，邮箱改变之后之前的链接自动失效
+func (app *application) verificationToken(user *models.User, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d", user.ID, expires.Unix())
+	return payload + "." + base64.RawURLEncoding.EncodeToString(app.verificationMAC(payload, user.Email))
+}
+
+func (app *application) verificationMAC(payload, email string) []byte {
+	mac := hmac.New(sha256.New, app.verifyKey)
+	mac.Write([]byte(payload + "." + email))
+	return mac.Sum(nil)
+}
+
+// checkVerificationToken 检查令牌的签名和有效期，返回令牌对应的用户
+// 令牌被篡改、已经过期或者用户不存在时返回ErrInvalidToken
+func (app *application) checkVerificationToken(token string) (*models.User, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, models.ErrInvalidToken
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, models.ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return nil, models.ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, models.ErrInvalidToken
+	}
+
+	user, err := app.users.Get(id)
+	if err == models.ErrNoRecord {
+		return nil, models.ErrInvalidToken
+	} else if err != nil {
+		return nil, err
+	}
+
+	if !hmac.Equal(sig, app.verificationMAC(parts[0]+"."+parts[1], user.Email)) {
+		return nil, models.ErrInvalidToken
+	}
+
+	return user, nil
+}
+
+// emailChangeToken 生成修改邮箱的确认令牌，形如"<用户id>.<过期时间>.<新邮箱>.<签名>"
+// 签名覆盖了用户当前的邮箱，邮箱修改之后其它还没有使用的确认链接自动失效
+func (app *application) emailChangeToken(user *models.User, newEmail string, expires time.Time) string {
+	payload := fmt.Sprintf("%d.%d.%s", user.ID, expires.Unix(), base64.RawURLEncoding.EncodeToString([]byte(newEmail)))
+	return payload + "." + base64.RawURLEncoding.EncodeToString(app.emailChangeMAC(payload, user.Email))
+}
+
+// emailChangeMAC 和verificationMAC使用相同的密钥，加上前缀区分两种令牌
+func (app *application) emailChangeMAC(payload, email string) []byte {
+	mac := hmac.New(sha256.New, app.verifyKey)
+	mac.Write([]byte("email-change." + payload + "." + email))
+	return mac.Sum(nil)
+}
+
+// checkEmailChangeToken 检查修改邮箱的令牌，返回令牌对应的用户和新邮箱
+func (app *application) checkEmailChangeToken(token string) (*models.User, string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 4 {
+		return nil, "", models.ErrInvalidToken
+	}
+
+	id, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return nil, "", models.ErrInvalidToken
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || time.Now().After(time.Unix(expires, 0)) {
+		return nil, "", models.ErrInvalidToken
+	}
+	newEmail, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, "", models.ErrInvalidToken
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return nil, "", models.ErrInvalidToken
+	}
+
+	user, err := app.users.Get(id)
+	if err == models.ErrNoRecord {
+		return nil, "", models.ErrInvalidToken
+	} else if err != nil {
+		return nil, "", err
+	}
+
+	if !hmac.Equal(sig, app.emailChangeMAC(strings.Join(parts[:3], "."), user.Email)) {
+		return nil, "", models.ErrInvalidToken
+	}
+
+	return user, string(newEmail), nil
+}
+
+// sendEmailChangeEmails 向新邮箱发送确认链接，同时通知旧邮箱有人申请修改邮箱
+func (app *application) sendEmailChangeEmails(user *models.User, newEmail string) error {
+	token := app.emailChangeToken(user, newEmail, time.Now().Add(emailVerificationTTL))
+	body := fmt.Sprintf("%s，你好：\n\n请在%d小时内打开下面的链接，确认把Snippetbox账号的邮箱修改为%s：\n\n%s/user/email/%s\n\n如果你没有申请修改邮箱，请忽略这封邮件。\n",
+		user.Name, int(emailVerificationTTL.Hours()), newEmail, app.baseURL, token)
+	err := app.mailer.Send(newEmail, "确认你的新Snippetbox邮箱", body)
+	if err != nil {
+		return err
+	}
+
+	notice := fmt.Sprintf("%s，你好：\n\n有人申请把你的Snippetbox账号的邮箱修改为%s，新邮箱确认之后才会生效。\n\n如果这不是你本人的操作，请立即修改密码。\n",
+		user.Name, newEmail)
+	return app.mailer.Send(user.Email, "你的Snippetbox邮箱正在被修改", notice)
+}
+
+// sendVerificationEmail 向用户的邮箱发送验证链接
+func (app *application) sendVerificationEmail(user *models.User) error {
+	token := app.verificationToken(user, time.Now().Add(emailVerificationTTL))
+	body := fmt.Sprintf("%s，你好：\n\n请在%d小时内打开下面的链接验证你的邮箱：\n\n%s/user/verify/%s\n\n如果你没有注册Snippetbox，请忽略这封邮件。\n",
+		user.Name, int(emailVerificationTTL.Hours()), app.baseURL, token)
+	return app.mailer.Send(user.Email, "验证你的Snippetbox邮箱", body)
+}
@@ -0,0 +1,283 @@
+// Package diff 实现按行比较两段文本，并把结果整理为unified diff格式的hunk
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Op 表示一行在比较结果中的操作类型
+type Op int
+
+const (
+	Equal Op = iota
+	Insert
+	Delete
+)
+
+// Line 表示比较结果中的一行，OldNum和NewNum是该行在旧/新文本中的行号，不存在时为0
+type Line struct {
+	Op     Op
+	Text   string
+	OldNum int
+	NewNum int
+}
+
+// Prefix 返回unified diff中该行的前缀符号
+func (l Line) Prefix() string {
+	switch l.Op {
+	case Insert:
+		return "+"
+	case Delete:
+		return "-"
+	default:
+		return " "
+	}
+}
+
+// Kind 返回该行操作的名称，方便在模版中作为CSS类名使用
+func (l Line) Kind() string {
+	switch l.Op {
+	case Insert:
+		return "insert"
+	case Delete:
+		return "delete"
+	default:
+		return "equal"
+	}
+}
+
+// Hunk 是unified diff中的一段连续变化以及它周围的上下文
+type Hunk struct {
+	OldStart int
+	OldLines int
+	NewStart int
+	NewLines int
+	Lines    []Line
+}
+
+// Header 返回形如 "@@ -1,3 +1,4 @@" 的hunk头部
+func (h Hunk) Header() string {
+	return fmt.Sprintf("@@ -%s +%s @@", span(h.OldStart, h.OldLines), span(h.NewStart, h.NewLines))
+}
+
+func span(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
+
+// splitLines 按行切分文本，统一换行符并忽略末尾的换行
+func splitLines(s string) []string {
+	s = strings.ReplaceAll(s, "\r\n", "\n")
+	s = strings.TrimSuffix(s, "\n")
+	if s == "" {
+		return nil
+	}
+	return strings.Split(s, "\n")
+}
+
+// maxCost 限制每次查找中间蛇形时最多尝试的编辑次数
+// 差异很大的文本超过这个次数之后，剩余的部分直接作为整块删除和插入，不再寻找最短的结果
+const maxCost = 1000
+
+// differ 使用Myers的线性空间算法比较两组行，keepX和keepY标记属于公共子序列的行
+type differ struct {
+	x, y         []string
+	keepX, keepY []bool
+}
+
+// compare 比较x[xlo:xhi]和y[ylo:yhi]，先去掉相同的前缀和后缀，再从中间蛇形处分成两半递归比较
+func (d *differ) compare(xlo, xhi, ylo, yhi int) {
+	for xlo < xhi && ylo < yhi && d.x[xlo] == d.y[ylo] {
+		d.keepX[xlo], d.keepY[ylo] = true, true
+		xlo++
+		ylo++
+	}
+	for xlo < xhi && ylo < yhi && d.x[xhi-1] == d.y[yhi-1] {
+		xhi--
+		yhi--
+		d.keepX[xhi], d.keepY[yhi] = true, true
+	}
+	if xlo == xhi || ylo == yhi {
+		return
+	}
+
+	xmid, ymid, ok := d.bisect(xlo, xhi, ylo, yhi)
+	if !ok {
+		return
+	}
+	d.compare(xlo, xmid, ylo, ymid)
+	d.compare(xmid, xhi, ymid, yhi)
+}
+
+// bisect 同时从两端搜索最短编辑路径，返回两个方向的路径相遇的位置
+// 只使用O(n+m)的内存，超过maxCost次编辑还没有相遇时返回false
+func (d *differ) bisect(xlo, xhi, ylo, yhi int) (int, int, bool) {
+	n, m := xhi-xlo, yhi-ylo
+	max := (n + m + 1) / 2
+	if max > maxCost {
+		max = maxCost
+	}
+	// v1[offset+k]是正向第k条对角线上走得最远的x，v2是反向从末尾开始走过的距离
+	offset := max
+	v1 := make([]int, 2*max+2)
+	v2 := make([]int, 2*max+2)
+	for i := range v1 {
+		v1[i], v2[i] = -1, -1
+	}
+	v1[offset+1], v2[offset+1] = 0, 0
+	delta := n - m
+	// 总长度为奇数时在正向检查相遇，否则在反向检查
+	front := delta%2 != 0
+	// 已经超出网格的对角线不再继续搜索
+	k1start, k1end, k2start, k2end := 0, 0, 0, 0
+
+	for e := 0; e < max; e++ {
+		for k := -e + k1start; k <= e-k1end; k += 2 {
+			var x int
+			if k == -e || (k != e && v1[offset+k-1] < v1[offset+k+1]) {
+				x = v1[offset+k+1]
+			} else {
+				x = v1[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.x[xlo+x] == d.y[ylo+y] {
+				x++
+				y++
+			}
+			v1[offset+k] = x
+			if x > n {
+				k1end += 2
+			} else if y > m {
+				k1start += 2
+			} else if front {
+				rk := offset + delta - k
+				if rk >= 0 && rk < len(v2) && v2[rk] != -1 && x >= n-v2[rk] {
+					return xlo + x, ylo + y, true
+				}
+			}
+		}
+		for k := -e + k2start; k <= e-k2end; k += 2 {
+			var x int
+			if k == -e || (k != e && v2[offset+k-1] < v2[offset+k+1]) {
+				x = v2[offset+k+1]
+			} else {
+				x = v2[offset+k-1] + 1
+			}
+			y := x - k
+			for x < n && y < m && d.x[xhi-x-1] == d.y[yhi-y-1] {
+				x++
+				y++
+			}
+			v2[offset+k] = x
+			if x > n {
+				k2end += 2
+			} else if y > m {
+				k2start += 2
+			} else if !front {
+				fk := offset + delta - k
+				if fk >= 0 && fk < len(v1) && v1[fk] != -1 && v1[fk] >= n-x {
+					fx := v1[fk]
+					return xlo + fx, ylo + fx - (fk - offset), true
+				}
+			}
+		}
+	}
+	return 0, 0, false
+}
+
+// Lines 逐行比较a和b，返回完整的比较结果（包括所有相同的行）
+func Lines(a, b string) []Line {
+	x, y := splitLines(a), splitLines(b)
+
+	d := &differ{x: x, y: y, keepX: make([]bool, len(x)), keepY: make([]bool, len(y))}
+	d.compare(0, len(x), 0, len(y))
+
+	// 按顺序输出，同一处变化中删除的行排在插入的行之前
+	lines := make([]Line, 0, len(x)+len(y))
+	i, j := 0, 0
+	for i < len(x) || j < len(y) {
+		switch {
+		case i < len(x) && j < len(y) && d.keepX[i] && d.keepY[j]:
+			i++
+			j++
+			lines = append(lines, Line{Op: Equal, Text: x[i-1], OldNum: i, NewNum: j})
+		case i < len(x) && !d.keepX[i]:
+			i++
+			lines = append(lines, Line{Op: Delete, Text: x[i-1], OldNum: i})
+		default:
+			j++
+			lines = append(lines, Line{Op: Insert, Text: y[j-1], NewNum: j})
+		}
+	}
+
+	return lines
+}
+
+// Unified 比较a和b，并把变化整理为hunk，每个变化前后保留context行上下文
+// 如果两段文本完全相同，返回nil
+func Unified(a, b string, context int) []Hunk {
+	lines := Lines(a, b)
+
+	// 先找出每个hunk在lines中覆盖的区间[start, end)，上下文重叠的变化合并为同一个hunk
+	var ranges [][2]int
+	for i, l := range lines {
+		if l.Op == Equal {
+			continue
+		}
+		start, end := i-context, i+1+context
+		if start < 0 {
+			start = 0
+		}
+		if end > len(lines) {
+			end = len(lines)
+		}
+		if n := len(ranges); n > 0 && start <= ranges[n-1][1] {
+			ranges[n-1][1] = end
+			continue
+		}
+		ranges = append(ranges, [2]int{start, end})
+	}
+
+	hunks := make([]Hunk, 0, len(ranges))
+	for _, r := range ranges {
+		h := Hunk{Lines: lines[r[0]:r[1]]}
+
+		// hunk之前已经出现过的旧/新行数
+		oldBefore, newBefore := 0, 0
+		for _, l := range lines[:r[0]] {
+			if l.Op != Insert {
+				oldBefore++
+			}
+			if l.Op != Delete {
+				newBefore++
+			}
+		}
+
+		for _, l := range h.Lines {
+			if l.Op != Insert {
+				h.OldLines++
+			}
+			if l.Op != Delete {
+				h.NewLines++
+			}
+		}
+
+		// 按照unified diff的约定，没有行的一侧起始行号为变化位置的前一行
+		h.OldStart, h.NewStart = oldBefore, newBefore
+		if h.OldLines > 0 {
+			h.OldStart++
+		}
+		if h.NewLines > 0 {
+			h.NewStart++
+		}
+		hunks = append(hunks, h)
+	}
+
+	if len(hunks) == 0 {
+		return nil
+	}
+	return hunks
+}
@@ -0,0 +1,156 @@
+package diff
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// 把hunk格式化为unified diff文本，方便与期望结果比较
+func format(hunks []Hunk) string {
+	var b strings.Builder
+	for _, h := range hunks {
+		b.WriteString(h.Header() + "\n")
+		for _, l := range h.Lines {
+			b.WriteString(l.Prefix() + l.Text + "\n")
+		}
+	}
+	return b.String()
+}
+
+func TestUnified(t *testing.T) {
+	tests := []struct {
+		name    string
+		a       string
+		b       string
+		context int
+		want    string
+	}{
+		{
+			name: "Identical",
+			a:    "one\ntwo\n",
+			b:    "one\ntwo",
+			want: "",
+		},
+		{
+			name:    "Changed line",
+			a:       "one\ntwo\nthree",
+			b:       "one\n2\nthree",
+			context: 1,
+			want:    "@@ -1,3 +1,3 @@\n one\n-two\n+2\n three\n",
+		},
+		{
+			name:    "From empty",
+			a:       "",
+			b:       "one\ntwo",
+			context: 3,
+			want:    "@@ -0,0 +1,2 @@\n+one\n+two\n",
+		},
+		{
+			name:    "Insert without context",
+			a:       "one\nthree",
+			b:       "one\ntwo\nthree",
+			context: 0,
+			want:    "@@ -1,0 +2 @@\n+two\n",
+		},
+		{
+			name:    "Separate hunks",
+			a:       "a\nb\nc\nd\ne\nf\ng\nh",
+			b:       "A\nb\nc\nd\ne\nf\ng\nH",
+			context: 1,
+			want:    "@@ -1,2 +1,2 @@\n-a\n+A\n b\n@@ -7,2 +7,2 @@\n g\n-h\n+H\n",
+		},
+		{
+			name:    "Merged hunks",
+			a:       "a\nb\nc\nd",
+			b:       "A\nb\nc\nD",
+			context: 1,
+			want:    "@@ -1,4 +1,4 @@\n-a\n+A\n b\n c\n-d\n+D\n",
+		},
+		{
+			name:    "CRLF line endings",
+			a:       "one\r\ntwo\r\n",
+			b:       "one\ntwo\nthree\n",
+			context: 1,
+			want:    "@@ -2 +2,2 @@\n two\n+three\n",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := format(Unified(tt.a, tt.b, tt.context))
+			if got != tt.want {
+				t.Errorf("want\n%s\ngot\n%s", tt.want, got)
+			}
+		})
+	}
+}
+
+// lcsLength 使用动态规划计算最长公共子序列的长度，作为最短编辑结果的参照
+func lcsLength(x, y []string) int {
+	prev := make([]int, len(y)+1)
+	for i := range x {
+		cur := make([]int, len(y)+1)
+		for j := range y {
+			if x[i] == y[j] {
+				cur[j+1] = prev[j] + 1
+			} else if prev[j+1] > cur[j] {
+				cur[j+1] = prev[j+1]
+			} else {
+				cur[j+1] = cur[j]
+			}
+		}
+		prev = cur
+	}
+	return prev[len(y)]
+}
+
+// 比较结果中旧的一侧和新的一侧分别还原为a和b，相同的行数等于最长公共子序列的长度
+func TestLinesMinimal(t *testing.T) {
+	rng := rand.New(rand.NewSource(1))
+	random := func(n int) string {
+		lines := make([]string, n)
+		for i := range lines {
+			lines[i] = string(rune('a' + rng.Intn(4)))
+		}
+		return strings.Join(lines, "\n")
+	}
+
+	for i := 0; i < 500; i++ {
+		a, b := random(rng.Intn(30)), random(rng.Intn(30))
+		var old, new []string
+		equal := 0
+		for _, l := range Lines(a, b) {
+			if l.Op != Insert {
+				old = append(old, l.Text)
+			}
+			if l.Op != Delete {
+				new = append(new, l.Text)
+			}
+			if l.Op == Equal {
+				equal++
+			}
+		}
+		if strings.Join(old, "\n") != a || strings.Join(new, "\n") != b {
+			t.Fatalf("%q -> %q: result does not reproduce the inputs", a, b)
+		}
+		if want := lcsLength(splitLines(a), splitLines(b)); equal != want {
+			t.Fatalf("%q -> %q: want %d equal lines; got %d", a, b, want, equal)
+		}
+	}
+}
+
+// 完全不同的大文本也要很快完成，并且不能按行数的乘积分配内存
+func TestLinesLarge(t *testing.T) {
+	var a, b strings.Builder
+	for i := 0; i < 30000; i++ {
+		fmt.Fprintf(&a, "old %d\n", i)
+		fmt.Fprintf(&b, "new %d\n", i)
+	}
+
+	lines := Lines(a.String(), b.String())
+	if len(lines) != 60000 {
+		t.Errorf("want 60000 lines; got %d", len(lines))
+	}
+}
@@ -1,6 +1,7 @@
 package forms
 
 import (
+	"encoding/base64"
 	"fmt"
 	"net/url"
 	"regexp"
@@ -12,6 +13,26 @@ import (
 // 运行时只编译一次正则表达式
 var EmailRX = regexp.MustCompile("^[a-zA-Z0-9.!#$%&'*+/=?^_`{|}~-]+@[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?(?:\\.[a-zA-Z0-9](?:[a-zA-Z0-9-]{0,61}[a-zA-Z0-9])?)*$")
 
+// TagRX 检查单个标签的格式：以字母或数字开头，只包含字母、数字和 _ . + # -，最长30个字符
+var TagRX = regexp.MustCompile(`^[\p{L}\p{N}][\p{L}\p{N}_.+#-]{0,29}$`)
+
+// SplitTags 将逗号分隔的标签字符串切分为标签切片
+// 去掉每个标签两端的空白并统一为小写，忽略空标签和重复的标签
+func SplitTags(value string) []string {
+	tags := []string{}
+	seen := map[string]bool{}
+	// 同时支持中文逗号
+	for _, tag := range strings.FieldsFunc(value, func(r rune) bool { return r == ',' || r == '，' }) {
+		tag = strings.ToLower(strings.TrimSpace(tag))
+		if tag == "" || seen[tag] {
+			continue
+		}
+		seen[tag] = true
+		tags = append(tags, tag)
+	}
+	return tags
+}
+
 // Form 创建一个自定义的表单结构体，嵌入了url.Values，去保存表单数据和错误属性
 // url.Values本身是一个map[string][]string类型，保存表单数据，键为字段名，值为字段真实的值
 type Form struct {
@@ -49,6 +70,13 @@ func (f *Form) MaxLength(field string, d int) {
 	}
 }
 
+// MaxBytes 与MaxLength相同，但是按字节而不是字符计算长度，用于bcrypt这样按字节截断的场合
+func (f *Form) MaxBytes(field string, d int) {
+	if len(f.Get(field)) > d {
+		f.Errors.Add(field, fmt.Sprintf("This field is too long (maximum is %d bytes)", d))
+	}
+}
+
 // PermittedValues 检查某个具体的属性是否匹配所给的允许的值set
 func (f *Form) PermittedValues(field string, opts ...string) {
 	value := f.Get(field)
@@ -98,6 +126,70 @@ func (f *Form) Matches(filed1, filed2 string) {
 	}
 }
 
+// Differs 检查两个表单字段的值不相同，例如新密码不能和当前密码一样
+func (f *Form) Differs(field1, field2 string) {
+	value1 := f.Get(field1)
+	value2 := f.Get(field2)
+
+	if value1 == "" || value2 == "" {
+		return
+	}
+	if value1 == value2 {
+		f.Errors.Add(field2, "The new value must be different from the current one")
+	}
+}
+
+// MaxTags 检查逗号分隔的标签字段最多包含d个标签
+func (f *Form) MaxTags(field string, d int) {
+	if len(SplitTags(f.Get(field))) > d {
+		f.Errors.Add(field, fmt.Sprintf("Too many tags (maximum is %d)", d))
+	}
+}
+
+// ValidTags 检查逗号分隔的标签字段中的每个标签都匹配TagRX
+func (f *Form) ValidTags(field string) {
+	for _, tag := range SplitTags(f.Get(field)) {
+		if !TagRX.MatchString(tag) {
+			f.Errors.Add(field, fmt.Sprintf("Invalid tag %q", tag))
+			return
+		}
+	}
+}
+
+// 浏览器使用AES-GCM加密，IV为12字节，密文最后是16字节的认证标签
+const (
+	gcmNonceSize = 12
+	gcmTagSize   = 16
+)
+
+// ValidCiphertext 检查字段是浏览器加密之后的内容，格式为"v1.<IV>.<密文>"
+// IV和密文都使用不带填充的base64url编码，密文解码之后最多max字节
+func (f *Form) ValidCiphertext(field string, max int) {
+	value := f.Get(field)
+	if value == "" {
+		return
+	}
+	parts := strings.Split(value, ".")
+	if len(parts) != 3 || parts[0] != "v1" {
+		f.Errors.Add(field, "Invalid ciphertext")
+		return
+	}
+	iv, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || len(iv) != gcmNonceSize {
+		f.Errors.Add(field, "Invalid ciphertext")
+		return
+	}
+	// 先按编码长度检查，避免解码过大的内容
+	if base64.RawURLEncoding.DecodedLen(len(parts[2])) > max {
+		f.Errors.Add(field, fmt.Sprintf("Ciphertext is too large (maximum is %d bytes)", max))
+		return
+	}
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(ciphertext) < gcmTagSize {
+		f.Errors.Add(field, "Invalid ciphertext")
+	}
+}
+
 // Valid 如果没有错误发生，返回true
 func (f *Form) Valid() bool {
 	return len(f.Errors) == 0
@@ -0,0 +1,94 @@
+// Package mailer 提供发送邮件的几种实现，生产环境使用SMTP，开发和测试时把邮件写入日志
+package mailer
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"mime"
+	"net"
+	"net/smtp"
+	"strings"
+	"time"
+)
+
+// ErrInvalidHeader 如果收件人、发件人或主题中包含换行符，防止邮件头注入
+var ErrInvalidHeader = errors.New("mailer: invalid header value")
+
+// Mailer 是发送纯文本邮件的接口
+type Mailer interface {
+	Send(to, subject, body string) error
+}
+
+// SMTP 通过SMTP服务器发送邮件，Username为空时不进行认证
+type SMTP struct {
+	Addr     string // 服务器地址，形如"smtp.example.com:587"
+	Username string
+	Password string
+	From     string
+}
+
+func (m *SMTP) Send(to, subject, body string) error {
+	msg, err := message(m.From, to, subject, body, time.Now())
+	if err != nil {
+		return err
+	}
+
+	var auth smtp.Auth
+	if m.Username != "" {
+		host, _, err := net.SplitHostPort(m.Addr)
+		if err != nil {
+			return err
+		}
+		auth = smtp.PlainAuth("", m.Username, m.Password, host)
+	}
+
+	return smtp.SendMail(m.Addr, auth, m.From, []string{to}, msg)
+}
+
+// Log 把邮件写入日志而不是真正发送，Logger可以输出到终端或者文件，用于开发和测试
+type Log struct {
+	Logger *log.Logger
+}
+
+func (m *Log) Send(to, subject, body string) error {
+	if invalidHeader(to, subject) {
+		return ErrInvalidHeader
+	}
+	m.Logger.Printf("To: %s\nSubject: %s\n\n%s\n", to, subject, body)
+	return nil
+}
+
+func invalidHeader(values ...string) bool {
+	for _, v := range values {
+		if strings.ContainsAny(v, "\r\n") {
+			return true
+		}
+	}
+	return false
+}
+
+// message 生成符合RFC 5322的邮件内容，主题使用MIME编码以支持中文
+func message(from, to, subject, body string, date time.Time) ([]byte, error) {
+	if invalidHeader(from, to, subject) {
+		return nil, ErrInvalidHeader
+	}
+
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "From: %s\r\n", from)
+	fmt.Fprintf(&buf, "To: %s\r\n", to)
+	fmt.Fprintf(&buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&buf, "Date: %s\r\n", date.Format(time.RFC1123Z))
+	buf.WriteString("MIME-Version: 1.0\r\n")
+	buf.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buf.WriteString("Content-Transfer-Encoding: 8bit\r\n")
+	buf.WriteString("\r\n")
+
+	// 邮件正文的换行统一为CRLF
+	body = strings.ReplaceAll(body, "\r\n", "\n")
+	buf.WriteString(strings.ReplaceAll(body, "\n", "\r\n"))
+	buf.WriteString("\r\n")
+
+	return buf.Bytes(), nil
+}
@@ -0,0 +1,78 @@
+package mailer
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMessage(t *testing.T) {
+	date := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name    string
+		to      string
+		subject string
+		body    string
+		want    []string
+		wantErr error
+	}{
+		{
+			name:    "ASCII",
+			to:      "alice@example.com",
+			subject: "Hello",
+			body:    "line 1\nline 2",
+			want: []string{
+				"From: Snippetbox <no-reply@example.com>\r\n",
+				"To: alice@example.com\r\n",
+				"Subject: Hello\r\n",
+				"Date: Thu, 17 Dec 2020 10:00:00 +0000\r\n",
+				"\r\n\r\nline 1\r\nline 2\r\n",
+			},
+		},
+		{
+			name:    "Encoded subject",
+			to:      "alice@example.com",
+			subject: "重置密码",
+			body:    "body",
+			want:    []string{"Subject: =?utf-8?q?"},
+		},
+		{
+			name:    "Header injection",
+			to:      "alice@example.com\r\nBcc: bob@example.com",
+			subject: "Hello",
+			wantErr: ErrInvalidHeader,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			msg, err := message("Snippetbox <no-reply@example.com>", tt.to, tt.subject, tt.body, date)
+			if err != tt.wantErr {
+				t.Fatalf("want error %v; got %v", tt.wantErr, err)
+			}
+			for _, want := range tt.want {
+				if !strings.Contains(string(msg), want) {
+					t.Errorf("want message to contain %q; got %q", want, msg)
+				}
+			}
+		})
+	}
+}
+
+func TestLog(t *testing.T) {
+	var buf bytes.Buffer
+	m := &Log{Logger: log.New(&buf, "", 0)}
+
+	err := m.Send("alice@example.com", "Hello", "body")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "To: alice@example.com\nSubject: Hello\n\nbody\n"
+	if got := buf.String(); got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
@@ -0,0 +1,26 @@
+package mock
+
+import (
+	"fmt"
+	"sync"
+)
+
+// ActivityModel 把账号操作保存在内存中，方便测试检查记录了哪些操作
+type ActivityModel struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *ActivityModel) Insert(userID int, ip, event string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, fmt.Sprintf("%d %s", userID, event))
+	return nil
+}
+
+// Events 返回记录过的操作，每个操作形如"用户id 事件名称"
+func (m *ActivityModel) Events() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.events...)
+}
@@ -0,0 +1,25 @@
+package mock
+
+import (
+	"sync"
+)
+
+// LoginEventModel 把登录事件保存在内存中，方便测试检查记录了哪些事件
+type LoginEventModel struct {
+	mu     sync.Mutex
+	events []string
+}
+
+func (m *LoginEventModel) Insert(email, ip, event string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.events = append(m.events, email+" "+event)
+	return nil
+}
+
+// Events 返回记录过的事件，每个事件形如"邮箱 事件名称"
+func (m *LoginEventModel) Events() []string {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return append([]string(nil), m.events...)
+}
@@ -0,0 +1,26 @@
+package mock
+
+import (
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"time"
+)
+
+// 模拟的重置令牌"validtoken"属于mockUser
+type PasswordResetModel struct{}
+
+func (m *PasswordResetModel) Insert(userID int, ttl time.Duration) (string, error) {
+	return "newresettoken", nil
+}
+
+func (m *PasswordResetModel) Check(plaintext string) (int, error) {
+	switch plaintext {
+	case "validtoken":
+		return 1, nil
+	default:
+		return 0, models.ErrInvalidToken
+	}
+}
+
+func (m *PasswordResetModel) Consume(plaintext string) (int, error) {
+	return m.Check(plaintext)
+}
@@ -2,33 +2,314 @@ package mock
 
 import (
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"strings"
 	"time"
 )
 
 // 模拟一个Snippet对象
 var mockSnippet = &models.Snippet{
-	ID:      1,
-	Title:   "An old silent pond",
-	Content: "An old silent pond...",
-	Created: time.Now(),
-	Expires: time.Now(),
+	ID:         1,
+	UserID:     1,
+	UserName:   "ltx",
+	Title:      "An old silent pond",
+	Content:    "An old silent pond...",
+	Format:     models.FormatPlain,
+	Visibility: models.VisibilityPublic,
+	Slug:       "r7Gx2kQpLm9w",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	Tags:       []string{"haiku"},
+}
+
+// 模拟用户1的一个不公开的snippet，只能通过slug访问
+var mockUnlisted = &models.Snippet{
+	ID:         3,
+	UserID:     1,
+	UserName:   "ltx",
+	Title:      "Over the wintry forest",
+	Content:    "Over the wintry forest, winds howl in rage...",
+	Format:     models.FormatPlain,
+	Visibility: models.VisibilityUnlisted,
+	Slug:       "u3Nq8vTzYc1e",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	Tags:       []string{},
+}
+
+// 模拟用户1的一个私密的snippet，只有作者可以访问
+var mockPrivate = &models.Snippet{
+	ID:         4,
+	UserID:     1,
+	UserName:   "ltx",
+	Title:      "First autumn morning",
+	Content:    "First autumn morning, the mirror I stare into...",
+	Format:     models.FormatPlain,
+	Visibility: models.VisibilityPrivate,
+	Slug:       "p5Kd0wHsJa4f",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	Tags:       []string{},
+}
+
+// 模拟用户1的一个阅后即焚的snippet
+var mockBurn = &models.Snippet{
+	ID:               5,
+	UserID:           1,
+	UserName:         "ltx",
+	Title:            "The light of a candle",
+	Content:          "The light of a candle is transferred to another candle...",
+	Format:           models.FormatPlain,
+	Visibility:       models.VisibilityPublic,
+	Slug:             "b9Rk3mVt6Hy2",
+	BurnAfterReading: true,
+	Created:          time.Now(),
+	Expires:          time.Now(),
+	Tags:             []string{},
+}
+
+// 模拟用户1的一个有查看密码的snippet，密码为mockSnippetPassword
+var mockProtected = &models.Snippet{
+	ID:         6,
+	UserID:     1,
+	UserName:   "ltx",
+	Title:      "Autumn moonlight",
+	Content:    "Autumn moonlight, a worm digs silently into the chestnut...",
+	Format:     models.FormatPlain,
+	Visibility: models.VisibilityPublic,
+	Slug:       "k2Pw7sLd3Nx8",
+	Protected:  true,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	Tags:       []string{},
+}
+
+const mockSnippetPassword = "open sesame"
+
+// 模拟用户1的一个在浏览器中加密的snippet，Content是密文
+var mockEncrypted = &models.Snippet{
+	ID:         7,
+	UserID:     1,
+	UserName:   "ltx",
+	Title:      "Encrypted haiku",
+	Content:    "v1.PB8wP2KwTscx0-Yc.KC6TmuJTRNS4Ddn-ls11bBBFpJFKKuCT4h2h75lc1rpQzeKDDA",
+	Format:     models.FormatEncrypted,
+	Visibility: models.VisibilityUnlisted,
+	Slug:       "e8Vc4jQm1Zs5",
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	Tags:       []string{},
+}
+
+// mockSnippets 是用户1所有模拟的snippet，公开的排在最前面
+var mockSnippets = []*models.Snippet{mockSnippet, mockUnlisted, mockPrivate, mockBurn, mockProtected, mockEncrypted}
+
+// 模拟一个作者注销账号之后匿名保留下来的阅后即焚的snippet，UserID为0
+var mockAnonymousBurn = &models.Snippet{
+	ID:               8,
+	UserName:         models.AnonymousAuthor,
+	Title:            "In the twilight rain",
+	Content:          "In the twilight rain these brilliant-hued hibiscus...",
+	Format:           models.FormatPlain,
+	Visibility:       models.VisibilityPublic,
+	Slug:             "a4Jt6nBw2Qx9",
+	BurnAfterReading: true,
+	Created:          time.Now(),
+	Expires:          time.Now(),
+	Tags:             []string{},
+}
+
+// 模拟一个作者注销账号之后匿名保留下来的有查看密码的snippet，密码为mockSnippetPassword
+var mockAnonymousProtected = &models.Snippet{
+	ID:         9,
+	UserName:   models.AnonymousAuthor,
+	Title:      "A summer river",
+	Content:    "A summer river being crossed, how pleasing with sandals in my hands...",
+	Format:     models.FormatPlain,
+	Visibility: models.VisibilityPublic,
+	Slug:       "q6Lf1xZr8Tb3",
+	Protected:  true,
+	Created:    time.Now(),
+	Expires:    time.Now(),
+	Tags:       []string{},
+}
+
+// mockAnonymized 是不属于任何用户的模拟snippet，只能通过id或者slug找到
+var mockAnonymized = []*models.Snippet{mockAnonymousBurn, mockAnonymousProtected}
+
+// allMockSnippets 返回包括匿名snippet在内的所有模拟snippet
+func allMockSnippets() []*models.Snippet {
+	all := append([]*models.Snippet{}, mockSnippets...)
+	return append(all, mockAnonymized...)
+}
+
+// 模拟snippet 1的两个版本，最新的版本排在最前面
+var mockRevisions = []*models.Revision{
+	{
+		SnippetID: 1,
+		Number:    2,
+		Title:     "An old silent pond",
+		Content:   "An old silent pond...",
+		Created:   time.Now(),
+	},
+	{
+		SnippetID: 1,
+		Number:    1,
+		Title:     "An old pond",
+		Content:   "An old pond...",
+		Created:   time.Now(),
+	},
 }
 
 type SnippetModel struct{}
 
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
+func (m *SnippetModel) Insert(s *models.Snippet, expires string) (int, error) {
+	s.Slug = "n2Wb6yRfEo7s"
 	return 2, nil
 }
 
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+func (m *SnippetModel) Get(id, viewerID int) (*models.Snippet, error) {
+	for _, s := range allMockSnippets() {
+		listed := s.Visibility == models.VisibilityPublic && !s.BurnAfterReading
+		if s.ID == id && (listed || s.UserID == viewerID) {
+			// 返回一个副本，防止处理器修改共享的模拟数据
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *SnippetModel) GetBySlug(slug string, viewerID int) (*models.Snippet, error) {
+	for _, s := range allMockSnippets() {
+		if s.Slug == slug && (s.Visibility != models.VisibilityPrivate || s.UserID == viewerID) {
+			c := *s
+			return &c, nil
+		}
+	}
+	return nil, models.ErrNoRecord
+}
+
+func (m *SnippetModel) Update(s *models.Snippet) error {
+	switch s.ID {
+	case 1, 3, 4, 5, 6, 7:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Delete(id int) error {
 	switch id {
+	case 1, 3, 4, 5, 6, 7:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) CheckPassword(id int, password string) error {
+	switch id {
+	case 6, 9:
+		if password == mockSnippetPassword {
+			return nil
+		}
+		return models.ErrInvalidCredentials
+	case 1, 3, 4, 5, 7, 8:
+		return models.ErrInvalidCredentials
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) Burn(id int) error {
+	switch id {
+	case 5, 8:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *SnippetModel) List(cursor *models.Cursor, limit int) (*models.Page, error) {
+	// 只有一个模拟的snippet，所以只有第一页有内容
+	if cursor != nil {
+		return &models.Page{Snippets: []*models.Snippet{}}, nil
+	}
+	return &models.Page{Snippets: []*models.Snippet{mockSnippet}}, nil
+}
+
+func (m *SnippetModel) ListByTag(tag string, cursor *models.Cursor, limit int) (*models.Page, error) {
+	if cursor == nil && tag == "haiku" {
+		return &models.Page{Snippets: []*models.Snippet{mockSnippet}}, nil
+	}
+	return &models.Page{Snippets: []*models.Snippet{}}, nil
+}
+
+func (m *SnippetModel) ListByUser(userID int, cursor *models.Cursor, limit int) (*models.Page, error) {
+	if cursor == nil && userID == mockSnippet.UserID {
+		return &models.Page{Snippets: []*models.Snippet{mockSnippet}}, nil
+	}
+	return &models.Page{Snippets: []*models.Snippet{}}, nil
+}
+
+func (m *SnippetModel) ListOwned(userID int, cursor *models.Cursor, limit int) (*models.Page, error) {
+	if cursor == nil && userID == mockSnippet.UserID {
+		return &models.Page{Snippets: mockSnippets}, nil
+	}
+	return &models.Page{Snippets: []*models.Snippet{}}, nil
+}
+
+func (m *SnippetModel) CountByUser(userID int) (int, error) {
+	if userID == mockSnippet.UserID {
+		return 1, nil
+	}
+	return 0, nil
+}
+
+func (m *SnippetModel) CountOwned(userID int) (int, error) {
+	if userID == mockSnippet.UserID {
+		return len(mockSnippets), nil
+	}
+	return 0, nil
+}
+
+func (m *SnippetModel) Export(userID int, fn func(*models.Snippet) error) error {
+	if userID != mockSnippet.UserID {
+		return nil
+	}
+	for _, s := range mockSnippets {
+		if err := fn(s); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (m *SnippetModel) TagCloud(limit int) ([]*models.Tag, error) {
+	return []*models.Tag{{Name: "haiku", Count: 1}}, nil
+}
+
+func (m *SnippetModel) Search(query string, offset, limit int) ([]*models.Snippet, error) {
+	if offset == 0 && strings.Contains(strings.ToLower(mockSnippet.Content), strings.ToLower(query)) {
+		return []*models.Snippet{mockSnippet}, nil
+	}
+	return []*models.Snippet{}, nil
+}
+
+func (m *SnippetModel) Revisions(snippetID int) ([]*models.Revision, error) {
+	switch snippetID {
 	case 1:
-		return mockSnippet, nil
+		return mockRevisions, nil
 	default:
-		return nil, models.ErrNoRecord
+		return []*models.Revision{}, nil
 	}
 }
 
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	return []*models.Snippet{mockSnippet}, nil
+func (m *SnippetModel) Revision(snippetID, number int) (*models.Revision, error) {
+	for _, rv := range mockRevisions {
+		if rv.SnippetID == snippetID && rv.Number == number {
+			return rv, nil
+		}
+	}
+	return nil, models.ErrNoRecord
 }
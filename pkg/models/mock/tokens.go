@@ -0,0 +1,56 @@
+package mock
+
+import (
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"time"
+)
+
+// 模拟的两个令牌都属于mockUser，分别只有读权限和读写权限
+var mockReadToken = &models.Token{
+	ID:      1,
+	UserID:  1,
+	Name:    "CI read",
+	Scope:   models.ScopeRead,
+	Created: time.Now(),
+}
+
+var mockWriteToken = &models.Token{
+	ID:      2,
+	UserID:  1,
+	Name:    "Deploy script",
+	Scope:   models.ScopeWrite,
+	Created: time.Now(),
+}
+
+type TokenModel struct{}
+
+func (m *TokenModel) Insert(userID int, name, scope string) (string, error) {
+	return "sbx_newtoken", nil
+}
+
+func (m *TokenModel) List(userID int) ([]*models.Token, error) {
+	switch userID {
+	case 1:
+		return []*models.Token{mockWriteToken, mockReadToken}, nil
+	default:
+		return []*models.Token{}, nil
+	}
+}
+
+func (m *TokenModel) Delete(userID, id int) error {
+	if userID == 1 && (id == 1 || id == 2) {
+		return nil
+	}
+	return models.ErrNoRecord
+}
+
+func (m *TokenModel) Authenticate(plaintext string) (*models.Token, error) {
+	switch plaintext {
+	case "sbx_readtoken":
+		return mockReadToken, nil
+	case "sbx_writetoken":
+		return mockWriteToken, nil
+	default:
+		return nil, models.ErrInvalidCredentials
+	}
+}
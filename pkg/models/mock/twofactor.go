@@ -0,0 +1,60 @@
+package mock
+
+import (
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+)
+
+// MockTOTPSecret 是mockTwoFactorUser的TOTP密钥，测试中用它计算验证码
+const MockTOTPSecret = "JBSWY3DPEHPK3PXPJBSWY3DPEHPK3PXP"
+
+// 模拟的恢复码，每次开启两步验证时都返回这些恢复码
+var mockRecoveryCodes = []string{"aaaaa-bbbbb", "ccccc-ddddd"}
+
+// mockUser没有设置两步验证，mockOtherUser已经开始设置但还没有开启，mockTwoFactorUser已经开启
+type TwoFactorModel struct{}
+
+func (m *TwoFactorModel) Secret(userID int) (string, bool, error) {
+	switch userID {
+	case 2:
+		return MockTOTPSecret, false, nil
+	case 3:
+		return MockTOTPSecret, true, nil
+	default:
+		return "", false, models.ErrNoRecord
+	}
+}
+
+func (m *TwoFactorModel) Begin(userID int, secret string) error {
+	return nil
+}
+
+func (m *TwoFactorModel) Enable(userID int, step int64) ([]string, error) {
+	switch userID {
+	case 2, 3:
+		return mockRecoveryCodes, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *TwoFactorModel) Disable(userID int) error {
+	return nil
+}
+
+func (m *TwoFactorModel) UseStep(userID int, step int64) error {
+	if userID == 3 {
+		return nil
+	}
+	return models.ErrInvalidToken
+}
+
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) error {
+	if userID == 3 && code == mockRecoveryCodes[0] {
+		return nil
+	}
+	return models.ErrInvalidToken
+}
+
+func (m *TwoFactorModel) RecoveryCodesLeft(userID int) (int, error) {
+	return len(mockRecoveryCodes), nil
+}
@@ -6,38 +6,123 @@ import (
 )
 
 var mockUser = &models.User{
-	ID:      1,
-	Name:    "ltx",
-	Email:   "1207793251@qq.com",
+	ID:            1,
+	Name:          "ltx",
+	Email:         "1207793251@qq.com",
+	Created:       time.Now(),
+	EmailVerified: true,
+}
+
+// 另一个用户，不拥有任何模拟的snippet并且还没有验证邮箱，用来测试权限检查
+var mockOtherUser = &models.User{
+	ID:      2,
+	Name:    "Alice Jones",
+	Email:   "alice@example.com",
 	Created: time.Now(),
 }
 
+// 已经开启两步验证的用户
+var mockTwoFactorUser = &models.User{
+	ID:            3,
+	Name:          "Carol",
+	Email:         "carol@example.com",
+	Created:       time.Now(),
+	EmailVerified: true,
+}
+
 type UserModel struct {
 }
 
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	switch email {
 	case "1207793251@qq.com":
-		return models.ErrDuplicateEmail
+		return 0, models.ErrDuplicateEmail
 	default:
-		return nil
+		return 4, nil
 	}
 }
 
+// 所有模拟用户的密码都是"validPa$$word"
 func (m *UserModel) Authenticate(email, password string) (int, error) {
+	if password != "validPa$$word" {
+		return 0, models.ErrInvalidCredentials
+	}
 	switch email {
 	case "1207793251@qq.com":
 		return 1, nil
+	case "alice@example.com":
+		return 2, nil
+	case "carol@example.com":
+		return 3, nil
 	default:
 		return 0, models.ErrInvalidCredentials
 	}
 }
 
+func (m *UserModel) CheckPassword(id int, password string) error {
+	if id < 1 || id > 3 || password != "validPa$$word" {
+		return models.ErrInvalidCredentials
+	}
+	return nil
+}
+
 func (m *UserModel) Get(id int) (*models.User, error) {
 	switch id {
 	case 1:
 		return mockUser, nil
+	case 2:
+		return mockOtherUser, nil
+	case 3:
+		return mockTwoFactorUser, nil
 	default:
 		return nil, models.ErrNoRecord
 	}
 }
+
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	switch email {
+	case "1207793251@qq.com":
+		return mockUser, nil
+	case "alice@example.com":
+		return mockOtherUser, nil
+	case "carol@example.com":
+		return mockTwoFactorUser, nil
+	default:
+		return nil, models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) UpdatePassword(id int, newPassword string) error {
+	return nil
+}
+
+func (m *UserModel) UpdateName(id int, name string) error {
+	return nil
+}
+
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	switch email {
+	case "1207793251@qq.com", "alice@example.com", "carol@example.com":
+		return models.ErrDuplicateEmail
+	default:
+		return nil
+	}
+}
+
+func (m *UserModel) Delete(id int, anonymize bool) error {
+	switch id {
+	case 1, 2, 3:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
+
+func (m *UserModel) VerifyEmail(id int) error {
+	switch id {
+	case 1, 2, 3:
+		return nil
+	default:
+		return models.ErrNoRecord
+	}
+}
@@ -1,7 +1,9 @@
 package models
 
 import (
+	"encoding/base64"
 	"errors"
+	"fmt"
 	"time"
 )
 
@@ -11,14 +13,112 @@ var (
 	ErrInvalidCredentials = errors.New("models: invalid credentials")
 	// ErrDuplicateEmail 如果注册时邮箱地址已经被使用了
 	ErrDuplicateEmail = errors.New("models: duplicate email")
+	// ErrInvalidCursor 如果分页游标无法解析
+	ErrInvalidCursor = errors.New("models: invalid cursor")
+	// ErrInvalidToken 如果一次性令牌不存在、已经使用过或者已经过期
+	ErrInvalidToken = errors.New("models: invalid or expired token")
 )
 
+// snippet内容的格式，决定展示时如何渲染Content
+// FormatEncrypted的Content是浏览器加密之后的密文，服务器没有密钥，只能原样保存和返回
+const (
+	FormatPlain     = "plain"
+	FormatMarkdown  = "markdown"
+	FormatEncrypted = "encrypted"
+)
+
+// snippet的可见性：公开的snippet出现在列表和搜索中；不公开的snippet只能通过随机的Slug访问；
+// 私密的snippet只有作者本人可以看到
+const (
+	VisibilityPublic   = "public"
+	VisibilityUnlisted = "unlisted"
+	VisibilityPrivate  = "private"
+)
+
+// AnonymousAuthor 是作者注销账号时选择保留（匿名化）的snippet展示的作者名字
+const AnonymousAuthor = "匿名用户"
+
+// Snippet 定义一个日志类型，UserID和UserName记录作者信息
+// 作者已经注销的snippet，UserID为0，UserName为AnonymousAuthor
 type Snippet struct {
-	ID      int
-	Title   string
-	Content string
-	Created time.Time
-	Expires time.Time
+	ID               int
+	UserID           int
+	UserName         string
+	Title            string
+	Content          string
+	Format           string
+	Language         string // 代码的语言，空字符串表示纯文本，auto表示展示时自动识别
+	Visibility       string // VisibilityPublic等常量之一
+	Slug             string // 创建时生成的随机字符串，用于snippet页面的地址
+	BurnAfterReading bool   // 阅后即焚，第一次被查看之后就删除
+	Protected        bool   // 是否设置了查看密码
+	Password         string // 创建时设置的查看密码明文，只在Insert中使用，查询时不会取出
+	Created          time.Time
+	Expires          time.Time
+	Tags             []string
+}
+
+// Expired 返回snippet是否已经过期
+func (s *Snippet) Expired() bool {
+	return time.Now().After(s.Expires)
+}
+
+// Link 返回snippet页面的地址，使用随机的Slug而不是自增的ID，这样无法通过地址推测出其它snippet
+func (s *Snippet) Link() string {
+	return "/s/" + s.Slug
+}
+
+// Tag 表示一个标签以及使用它的snippet数量
+type Tag struct {
+	Name  string
+	Count int
+}
+
+// Cursor 用于按(created, id)进行键集分页，记录一页边界上snippet的位置
+// Backward为false时取比游标更早的一页（下一页），为true时取比游标更新的一页（上一页）
+type Cursor struct {
+	Created  time.Time
+	ID       int
+	Backward bool
+}
+
+// String 将游标的位置编码为可以放在URL中的字符串，不包括方向
+func (c *Cursor) String() string {
+	raw := fmt.Sprintf("%d.%d", c.Created.UnixNano(), c.ID)
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// ParseCursor 解析String编码的游标
+func ParseCursor(s string, backward bool) (*Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, ErrInvalidCursor
+	}
+
+	var nsec int64
+	var id int
+	_, err = fmt.Sscanf(string(raw), "%d.%d", &nsec, &id)
+	if err != nil || id < 1 {
+		return nil, ErrInvalidCursor
+	}
+
+	return &Cursor{Created: time.Unix(0, nsec).UTC(), ID: id, Backward: backward}, nil
+}
+
+// Page 是分页查询的一页结果，Next和Prev为nil表示没有下一页或上一页
+type Page struct {
+	Snippets []*Snippet
+	Next     *Cursor
+	Prev     *Cursor
+}
+
+// Revision 表示snippet某一次保存时的版本，Number从1开始递增
+type Revision struct {
+	SnippetID int
+	Number    int
+	Title     string
+	Content   string
+	Created   time.Time
 }
 
 // User 定义一个用户类型
@@ -28,4 +128,21 @@ type User struct {
 	Email          string
 	HashedPassword []byte
 	Created        time.Time
+	EmailVerified  bool
+}
+
+// API令牌的权限范围，write同时包含read的权限
+const (
+	ScopeRead  = "read"
+	ScopeWrite = "write"
+)
+
+// Token 表示用户创建的API令牌，数据库中只保存令牌的哈希值，明文只在创建时返回一次
+type Token struct {
+	ID       int
+	UserID   int
+	Name     string
+	Scope    string
+	Created  time.Time
+	LastUsed time.Time // 从未使用过时为零值
 }
@@ -0,0 +1,17 @@
+package mysql
+
+import (
+	"database/sql"
+)
+
+// ActivityModel 记录账号的重要操作，例如修改密码
+type ActivityModel struct {
+	DB *sql.DB
+}
+
+// Insert 记录用户的一次操作，event是password_changed等事件名称
+func (m *ActivityModel) Insert(userID int, ip, event string) error {
+	stmt := `INSERT INTO account_activity (user_id, ip, event, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, userID, ip, event)
+	return err
+}
@@ -0,0 +1,72 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/throttle"
+	"time"
+)
+
+// LoginFailureModel 把登录失败的记录保存在MySQL中，实现了throttle.Store
+// 多个进程共享同一个数据库时，失败次数可以在进程之间共享，重启之后也不会丢失
+type LoginFailureModel struct {
+	DB *sql.DB
+}
+
+func (m *LoginFailureModel) Get(key string) (throttle.Entry, error) {
+	var e throttle.Entry
+	stmt := `SELECT failures, last_failure FROM login_failures WHERE throttle_key = ?`
+	err := m.DB.QueryRow(stmt, key).Scan(&e.Failures, &e.Last)
+	if err == sql.ErrNoRows {
+		return throttle.Entry{}, nil
+	}
+	return e, err
+}
+
+// Incr 在数据库中原子地增加失败次数，同时失败的请求都会被计数
+// 在事务中读取更新之后的记录，行锁保证读到的是这次增加之后的次数
+func (m *LoginFailureModel) Incr(key string, now, since time.Time) (throttle.Entry, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return throttle.Entry{}, err
+	}
+	defer tx.Rollback()
+
+	// failures在last_failure之前赋值，所以比较的是更新之前的最后失败时间
+	stmt := `INSERT INTO login_failures (throttle_key, failures, last_failure) VALUES(?, 1, ?)
+	ON DUPLICATE KEY UPDATE failures = IF(last_failure < ?, 1, failures + 1), last_failure = VALUES(last_failure)`
+	_, err = tx.Exec(stmt, key, now.UTC(), since.UTC())
+	if err != nil {
+		return throttle.Entry{}, err
+	}
+
+	var e throttle.Entry
+	stmt = `SELECT failures, last_failure FROM login_failures WHERE throttle_key = ?`
+	err = tx.QueryRow(stmt, key).Scan(&e.Failures, &e.Last)
+	if err != nil {
+		return throttle.Entry{}, err
+	}
+	return e, tx.Commit()
+}
+
+// Decr 撤销一次失败，次数已经为0时不再减少
+func (m *LoginFailureModel) Decr(key string) error {
+	_, err := m.DB.Exec(`UPDATE login_failures SET failures = failures - 1 WHERE throttle_key = ? AND failures > 0`, key)
+	return err
+}
+
+func (m *LoginFailureModel) Delete(key string) error {
+	_, err := m.DB.Exec(`DELETE FROM login_failures WHERE throttle_key = ?`, key)
+	return err
+}
+
+// LoginEventModel 记录登录相关的事件，用于审计
+type LoginEventModel struct {
+	DB *sql.DB
+}
+
+// Insert 记录一次登录事件，event是success、failure、locked等事件名称
+func (m *LoginEventModel) Insert(email, ip, event string) error {
+	stmt := `INSERT INTO login_events (email, ip, event, created) VALUES(?, ?, ?, UTC_TIMESTAMP())`
+	_, err := m.DB.Exec(stmt, email, ip, event)
+	return err
+}
@@ -0,0 +1,61 @@
+package mysql
+
+import (
+	"testing"
+	"time"
+)
+
+func TestLoginFailureModelIncr(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := LoginFailureModel{db}
+	now := time.Now().UTC().Truncate(time.Second)
+
+	// 同时失败的请求都要被计数
+	errs := make(chan error, 20)
+	for i := 0; i < cap(errs); i++ {
+		go func() {
+			_, err := m.Incr("email:alice@example.com", now, now.Add(-time.Hour))
+			errs <- err
+		}()
+	}
+	for i := 0; i < cap(errs); i++ {
+		if err := <-errs; err != nil {
+			t.Fatal(err)
+		}
+	}
+	e, err := m.Get("email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Failures != cap(errs) {
+		t.Errorf("want %d failures; got %d", cap(errs), e.Failures)
+	}
+
+	// 撤销一次失败不改变最后失败时间
+	if err := m.Decr("email:alice@example.com"); err != nil {
+		t.Fatal(err)
+	}
+	e, err = m.Get("email:alice@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Failures != cap(errs)-1 || !e.Last.Equal(now) {
+		t.Errorf("want %d failures at %v; got %d at %v", cap(errs)-1, now, e.Failures, e.Last)
+	}
+
+	// 最后一次失败太久以前时重新开始计数
+	later := now.Add(2 * time.Hour)
+	e, err = m.Incr("email:alice@example.com", later, later.Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Failures != 1 || !e.Last.Equal(later) {
+		t.Errorf("want 1 failure at %v; got %d at %v", later, e.Failures, e.Last)
+	}
+}
@@ -0,0 +1,82 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"time"
+)
+
+// PasswordResetModel 管理找回密码时通过邮件发送的一次性令牌
+type PasswordResetModel struct {
+	DB *sql.DB
+}
+
+// Insert 为用户生成一个在ttl之后过期的重置令牌，返回令牌明文
+// 每个用户同时只有一个有效的重置令牌，之前生成的令牌会失效
+func (m *PasswordResetModel) Insert(userID int, ttl time.Duration) (string, error) {
+	plaintext, hash, err := generateToken("")
+	if err != nil {
+		return "", err
+	}
+
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO password_resets (user_id, hash, expires)
+	VALUES(?, ?, DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? SECOND))`
+
+	_, err = tx.Exec(stmt, userID, hash, int(ttl.Seconds()))
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, tx.Commit()
+}
+
+// Check 检查重置令牌是否有效，返回对应的用户id，但不会使令牌失效
+func (m *PasswordResetModel) Check(plaintext string) (int, error) {
+	var userID int
+	stmt := `SELECT user_id FROM password_resets WHERE hash = ? AND expires > UTC_TIMESTAMP()`
+	err := m.DB.QueryRow(stmt, hashToken(plaintext)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrInvalidToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// Consume 使用重置令牌，返回对应的用户id，令牌使用后立即失效
+func (m *PasswordResetModel) Consume(plaintext string) (int, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// 锁住这一行，防止同一个令牌被并发使用两次
+	var userID int
+	stmt := `SELECT user_id FROM password_resets WHERE hash = ? AND expires > UTC_TIMESTAMP() FOR UPDATE`
+	err = tx.QueryRow(stmt, hashToken(plaintext)).Scan(&userID)
+	if err == sql.ErrNoRows {
+		return 0, models.ErrInvalidToken
+	} else if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.Exec(`DELETE FROM password_resets WHERE user_id = ?`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	return userID, tx.Commit()
+}
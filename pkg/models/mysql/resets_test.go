@@ -0,0 +1,55 @@
+package mysql
+
+import (
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"testing"
+	"time"
+)
+
+func TestPasswordResetModel(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := PasswordResetModel{db}
+
+	// 已经过期的令牌不能使用
+	expired, err := m.Insert(1, -time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Check(expired); err != models.ErrInvalidToken {
+		t.Errorf("want %v for expired token; got %v", models.ErrInvalidToken, err)
+	}
+
+	// 生成新令牌后，之前的令牌失效
+	old, err := m.Insert(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := m.Insert(1, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := m.Check(old); err != models.ErrInvalidToken {
+		t.Errorf("want %v for replaced token; got %v", models.ErrInvalidToken, err)
+	}
+
+	// Check不会使令牌失效，Consume之后令牌不能再次使用
+	for i := 0; i < 2; i++ {
+		userID, err := m.Check(token)
+		if err != nil || userID != 1 {
+			t.Errorf("want user 1; got %d, %v", userID, err)
+		}
+	}
+	userID, err := m.Consume(token)
+	if err != nil || userID != 1 {
+		t.Errorf("want user 1; got %d, %v", userID, err)
+	}
+	if _, err := m.Consume(token); err != models.ErrInvalidToken {
+		t.Errorf("want %v for used token; got %v", models.ErrInvalidToken, err)
+	}
+}
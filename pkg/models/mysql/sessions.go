@@ -0,0 +1,110 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/sessions"
+)
+
+// 数据库中user_agent列的最大长度
+const maxUserAgent = 255
+
+// SessionModel 把会话保存在MySQL中，实现了sessions.Store
+type SessionModel struct {
+	DB *sql.DB
+}
+
+func (m *SessionModel) Find(id string) (*sessions.Record, error) {
+	stmt := `SELECT id, user_id, user_agent, ip, data, created, last_seen, expires FROM sessions
+	WHERE id = ? AND expires > UTC_TIMESTAMP()`
+
+	r := &sessions.Record{}
+	err := m.DB.QueryRow(stmt, id).Scan(&r.ID, &r.UserID, &r.UserAgent, &r.IP, &r.Data, &r.Created, &r.LastSeen, &r.Expires)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	} else if err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// truncateUserAgent 截断过长的User-Agent，使它可以放进user_agent列
+func truncateUserAgent(userAgent string) string {
+	if len(userAgent) > maxUserAgent {
+		return userAgent[:maxUserAgent]
+	}
+	return userAgent
+}
+
+func (m *SessionModel) Insert(r *sessions.Record) error {
+	stmt := `INSERT INTO sessions (id, user_id, user_agent, ip, data, created, last_seen, expires)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?)`
+
+	_, err := m.DB.Exec(stmt, r.ID, r.UserID, truncateUserAgent(r.UserAgent), r.IP, r.Data, r.Created.UTC(), r.LastSeen.UTC(), r.Expires.UTC())
+	return err
+}
+
+// Update 只更新已有的会话，已经被撤销的会话返回sessions.ErrNotFound，不会被重新创建
+func (m *SessionModel) Update(r *sessions.Record) error {
+	stmt := `UPDATE sessions SET user_id = ?, user_agent = ?, ip = ?, data = ?, last_seen = ? WHERE id = ?`
+
+	result, err := m.DB.Exec(stmt, r.UserID, truncateUserAgent(r.UserAgent), r.IP, r.Data, r.LastSeen.UTC(), r.ID)
+	if err != nil {
+		return err
+	}
+	n, err := result.RowsAffected()
+	if err != nil || n > 0 {
+		return err
+	}
+
+	// 值没有变化时影响的行数也是0，所以再确认一次会话是否还存在
+	var exists bool
+	err = m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM sessions WHERE id = ?)`, r.ID).Scan(&exists)
+	if err != nil {
+		return err
+	}
+	if !exists {
+		return sessions.ErrNotFound
+	}
+	return nil
+}
+
+func (m *SessionModel) DeleteExpired() error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE expires < UTC_TIMESTAMP()`)
+	return err
+}
+
+func (m *SessionModel) Delete(id string) error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE id = ?`, id)
+	return err
+}
+
+// List 返回用户所有未过期的会话，不包括会话数据
+func (m *SessionModel) List(userID int) ([]*sessions.Record, error) {
+	stmt := `SELECT id, user_id, user_agent, ip, created, last_seen, expires FROM sessions
+	WHERE user_id = ? AND expires > UTC_TIMESTAMP() ORDER BY last_seen DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	records := []*sessions.Record{}
+	for rows.Next() {
+		r := &sessions.Record{}
+		err = rows.Scan(&r.ID, &r.UserID, &r.UserAgent, &r.IP, &r.Created, &r.LastSeen, &r.Expires)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+func (m *SessionModel) DeleteUser(userID int, except string) error {
+	_, err := m.DB.Exec(`DELETE FROM sessions WHERE user_id = ? AND id <> ?`, userID, except)
+	return err
+}
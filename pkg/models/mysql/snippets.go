@@ -1,8 +1,13 @@
 package mysql
 
 import (
+	"crypto/rand"
 	"database/sql"
+	"encoding/base64"
 	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"github.com/go-sql-driver/mysql"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
 )
 
 // SnippetModel 定义一个Model类型包裹着sql.DB连接池
@@ -10,19 +15,98 @@ type SnippetModel struct {
 	DB *sql.DB
 }
 
-// Insert 插入一个新的snippet到数据库中，并返回对应的id
-func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
-	// 书写sql语句
-	stmt := `INSERT INTO snippets (title, content, created, expires)
-	VALUES(?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+// snippetColumns 是查询snippet时需要的所有列，连接users表以便同时取出作者的名字
+// 作者注销之后匿名化的snippet没有user_id，所以使用LEFT JOIN
+// 必须与scanSnippet中的顺序保持一致
+const snippetColumns = `s.id, COALESCE(s.user_id, 0), COALESCE(u.name, '` + models.AnonymousAuthor + `'),
+	s.title, s.content, s.format, s.language, s.visibility, s.slug, s.burn_after_reading,
+	s.hashed_password IS NOT NULL, s.created, s.expires
+	FROM snippets s LEFT JOIN users u ON s.user_id = u.id`
+
+// scanner 是*sql.Row和*sql.Rows共同的Scan方法
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanSnippet 将snippetColumns查询出的一行复制到新的snippet结构体中
+func scanSnippet(row scanner) (*models.Snippet, error) {
+	s := &models.Snippet{}
+	// driver 自动将原始的SQL数据库中的输出转换为需要的Go类型
+	// char,varchar,text->string;time,date,timestamp->time.Time
+	err := row.Scan(&s.ID, &s.UserID, &s.UserName, &s.Title, &s.Content, &s.Format, &s.Language, &s.Visibility, &s.Slug, &s.BurnAfterReading, &s.Protected, &s.Created, &s.Expires)
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
 
-	// 使用Exec方法去执行sql语句，后面的参数用来填充占位符?，不会将内容作为sql语句的一部分，就是简单的值
-	// Exec返回一个sql.Result接口
-	// 创建了一个prepared statement，数据库提前编译了
-	result, err := m.DB.Exec(stmt, title, content, expires)
+// slugAttempts 是slug与已有的snippet重复时最多尝试的次数
+const slugAttempts = 5
+
+// newSlug 生成一个随机的、可以放在URL中的slug，9个随机字节编码后为12个字符
+// 定义为变量是为了在测试中模拟重复的slug
+var newSlug = func() (string, error) {
+	b := make([]byte, 9)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// isDuplicateSlug 检查错误是否是违反了slug的唯一约束
+func isDuplicateSlug(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "idx_snippets_slug")
+}
+
+// Insert 插入一个新的snippet到数据库中，使用s中的作者、标题、内容、格式、语言、可见性、是否阅后即焚和标签，并返回对应的id
+// expires是从现在开始的有效天数，同时保存第一个版本到snippet_revisions中
+// s.Password不为空时，与用户的密码一样保存它的bcrypt哈希
+// 新snippet随机生成的slug会被写入s.Slug，与已有的slug重复时重新生成
+func (m *SnippetModel) Insert(s *models.Snippet, expires string) (int, error) {
+	// 没有密码时保存为NULL
+	var hashedPassword []byte
+	if s.Password != "" {
+		var err error
+		hashedPassword, err = bcrypt.GenerateFromPassword([]byte(s.Password), 12)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	// snippet和它的第一个版本必须同时写入，所以使用事务
+	tx, err := m.DB.Begin()
 	if err != nil {
 		return 0, err
 	}
+	// 如果提交之前出错就回滚，提交之后调用Rollback不会有任何效果
+	defer tx.Rollback()
+
+	// 书写sql语句
+	stmt := `INSERT INTO snippets (user_id, title, content, format, language, visibility, slug, burn_after_reading,
+	hashed_password, created, expires)
+	VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, UTC_TIMESTAMP(), DATE_ADD(UTC_TIMESTAMP(), INTERVAL ? DAY))`
+
+	var slug string
+	var result sql.Result
+	for attempt := 1; ; attempt++ {
+		slug, err = newSlug()
+		if err != nil {
+			return 0, err
+		}
+
+		// 使用Exec方法去执行sql语句，后面的参数用来填充占位符?，不会将内容作为sql语句的一部分，就是简单的值
+		// Exec返回一个sql.Result接口
+		// 违反唯一约束只会让这一条语句失败，事务仍然可以继续使用
+		result, err = tx.Exec(stmt, s.UserID, s.Title, s.Content, s.Format, s.Language, s.Visibility, slug, s.BurnAfterReading,
+			hashedPassword, expires)
+		if err == nil {
+			break
+		}
+		if !isDuplicateSlug(err) || attempt == slugAttempts {
+			return 0, err
+		}
+	}
 
 	// 获取插入记录后的id，只是mysqlDriver特供方法
 	id, err := result.LastInsertId()
@@ -30,49 +114,263 @@ func (m *SnippetModel) Insert(title, content, expires string) (int, error) {
 		return 0, err
 	}
 
+	err = insertRevision(tx, int(id), s.Title, s.Content)
+	if err != nil {
+		return 0, err
+	}
+
+	err = setTags(tx, int(id), s.Tags)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	s.Slug = slug
 	// 将int64类型的ID转换为int类型
 	return int(id), nil
 }
 
-// Get 根据id返回一个具体的snippet
-func (m *SnippetModel) Get(id int) (*models.Snippet, error) {
+// insertRevision 在事务中为snippet保存一个新版本，版本号为当前最大版本号加一
+func insertRevision(tx *sql.Tx, snippetID int, title, content string) error {
+	stmt := `INSERT INTO snippet_revisions (snippet_id, revision, title, content, created)
+	SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ?, UTC_TIMESTAMP()
+	FROM snippet_revisions WHERE snippet_id = ?`
+
+	_, err := tx.Exec(stmt, snippetID, title, content, snippetID)
+	return err
+}
+
+// Get 根据id返回一个具体的snippet，viewerID是当前用户的id，没有登录时为0
+// 通过id只能访问公开的、不是阅后即焚的snippet，作者本人除外
+func (m *SnippetModel) Get(id, viewerID int) (*models.Snippet, error) {
 	// Write the SQL statement we want to execute
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() AND id = ?`
+	stmt := `SELECT ` + snippetColumns + `
+	WHERE ` + unexpired + ` AND s.id = ?
+	AND ((s.visibility = '` + models.VisibilityPublic + `' AND NOT s.burn_after_reading) OR s.user_id = ?)`
 
-	// 使用QueryRow()方法查询单一的行结果
-	row := m.DB.QueryRow(stmt, id)
+	return m.getOne(stmt, id, viewerID)
+}
 
-	// 初始化一个指针指向新的snippet struct
-	s := &models.Snippet{}
+// GetBySlug 根据slug返回一个具体的snippet，公开和不公开的snippet都可以通过slug访问
+// 私密的snippet只有作者本人可以访问
+func (m *SnippetModel) GetBySlug(slug string, viewerID int) (*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + `
+	WHERE ` + unexpired + ` AND s.slug = ? AND (s.visibility <> '` + models.VisibilityPrivate + `' OR s.user_id = ?)`
 
-	// 使用row.Scan从查询到的结果中复制每个属性值给新的结构体
-	// driver 自动将原始的SQL数据库中的输出转换为需要的Go类型
-	// char,varchar,text->string;time,date,timestamp->time.Time
-	err := row.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+	return m.getOne(stmt, slug, viewerID)
+}
+
+// getOne 执行只返回一个snippet的查询，并取出它的标签
+func (m *SnippetModel) getOne(stmt string, args ...interface{}) (*models.Snippet, error) {
+	// 使用QueryRow()方法查询单一的行结果
+	// 使用scanSnippet从查询到的结果中复制每个属性值给新的结构体
+	s, err := scanSnippet(m.DB.QueryRow(stmt, args...))
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNoRecord
 	} else if err != nil {
 		return nil, err
 	}
 
+	s.Tags, err = m.tags(s.ID)
+	if err != nil {
+		return nil, err
+	}
+
 	// 如果一切正常，返回新结构体
 	return s, nil
 }
 
-// Latest 返回10个最近创建的snippet,
-func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
-	// 多结果查询ORDER BY DESC LIMIT
-	stmt := `SELECT id, title, content, created, expires FROM snippets
-	WHERE expires > UTC_TIMESTAMP() ORDER BY created DESC LIMIT 10`
+// Update 根据s.ID修改snippet的标题、内容、格式、语言、可见性和标签，不改变过期时间
+// 每次修改都会保存为一个新的版本，而不是覆盖旧的内容
+func (m *SnippetModel) Update(s *models.Snippet) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt := `UPDATE snippets SET title = ?, content = ?, format = ?, language = ?, visibility = ? WHERE id = ?`
+
+	_, err = tx.Exec(stmt, s.Title, s.Content, s.Format, s.Language, s.Visibility, s.ID)
+	if err != nil {
+		return err
+	}
+
+	err = insertRevision(tx, s.ID, s.Title, s.Content)
+	if err != nil {
+		return err
+	}
+
+	err = setTags(tx, s.ID, s.Tags)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Delete 根据id删除一个snippet
+func (m *SnippetModel) Delete(id int) error {
+	stmt := `DELETE FROM snippets WHERE id = ?`
+
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// CheckPassword 检查snippet的查看密码，密码错误或者snippet没有设置密码时返回ErrInvalidCredentials
+func (m *SnippetModel) CheckPassword(id int, password string) error {
+	var hashedPassword []byte
+	err := m.DB.QueryRow(`SELECT hashed_password FROM snippets WHERE id = ?`, id).Scan(&hashedPassword)
+	if err == sql.ErrNoRows {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+	if hashedPassword == nil {
+		return models.ErrInvalidCredentials
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return models.ErrInvalidCredentials
+	}
+	return err
+}
+
+// Burn 删除一个阅后即焚的snippet，只有一个请求可以删除成功，其它同时查看的请求得到ErrNoRecord
+// 所以调用者应该在Burn成功之后才展示之前取出的内容
+func (m *SnippetModel) Burn(id int) error {
+	stmt := `DELETE FROM snippets WHERE id = ? AND burn_after_reading = TRUE`
+
+	result, err := m.DB.Exec(stmt, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// unexpired 是只查询未过期snippet的条件
+const unexpired = `s.expires > UTC_TIMESTAMP()`
+
+// listed 是可以出现在公开列表、搜索和标签中的snippet的条件
+// 阅后即焚的snippet出现在列表中会被随便哪个访问者删除，所以不列出
+// 有密码的snippet也不列出，否则搜索结果的摘要会泄露内容
+const listed = unexpired + ` AND s.visibility = '` + models.VisibilityPublic + `' AND NOT s.burn_after_reading
+	AND s.hashed_password IS NULL`
+
+// List 按创建时间从新到旧返回一页公开的未过期的snippet，每页最多limit个
+// 使用(created, id)进行键集分页，cursor为nil时返回第一页
+func (m *SnippetModel) List(cursor *models.Cursor, limit int) (*models.Page, error) {
+	return m.listPage(listed, nil, cursor, limit)
+}
+
+// ListByUser 按创建时间从新到旧返回某个用户的一页公开的未过期的snippet
+func (m *SnippetModel) ListByUser(userID int, cursor *models.Cursor, limit int) (*models.Page, error) {
+	return m.listPage(listed+` AND s.user_id = ?`, []interface{}{userID}, cursor, limit)
+}
+
+// ListOwned 和ListByUser相同，但是包括已经过期的和不公开的snippet，用于用户查看自己的snippet
+func (m *SnippetModel) ListOwned(userID int, cursor *models.Cursor, limit int) (*models.Page, error) {
+	return m.listPage(`s.user_id = ?`, []interface{}{userID}, cursor, limit)
+}
+
+// CountByUser 返回某个用户公开的未过期的snippet数量
+func (m *SnippetModel) CountByUser(userID int) (int, error) {
+	var count int
+	stmt := `SELECT COUNT(*) FROM snippets s WHERE ` + listed + ` AND s.user_id = ?`
+	err := m.DB.QueryRow(stmt, userID).Scan(&count)
+	return count, err
+}
+
+// CountOwned 返回某个用户自己所有的snippet数量，和ListOwned一样包括已经过期的和不公开的snippet
+func (m *SnippetModel) CountOwned(userID int) (int, error) {
+	var count int
+	stmt := `SELECT COUNT(*) FROM snippets s WHERE s.user_id = ?`
+	err := m.DB.QueryRow(stmt, userID).Scan(&count)
+	return count, err
+}
+
+// Export 按创建时间从旧到新把某个用户所有的snippet逐个交给fn，包括已经过期的，用于导出个人数据
+// 一边读取查询结果一边处理，不需要把所有snippet放在内存中，fn返回错误时停止并返回这个错误
+func (m *SnippetModel) Export(userID int, fn func(*models.Snippet) error) error {
+	stmt := `SELECT ` + snippetColumns + `
+	WHERE s.user_id = ? ORDER BY s.created ASC, s.id ASC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return err
+		}
+		// 查询结果还没有读完，标签通过连接池中的另一个连接查询
+		s.Tags, err = m.tags(s.ID)
+		if err != nil {
+			return err
+		}
+		if err = fn(s); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+// listPage 是所有键集分页查询的公共部分，where是查询的条件
+func (m *SnippetModel) listPage(where string, args []interface{}, cursor *models.Cursor, limit int) (*models.Page, error) {
+	// 多取一条记录，用来判断当前方向上是否还有更多的页
+	stmt := `SELECT ` + snippetColumns + `
+	WHERE ` + where
+
+	switch {
+	case cursor == nil:
+		stmt += ` ORDER BY s.created DESC, s.id DESC LIMIT ?`
+	case cursor.Backward:
+		// 向前翻页时按升序取出比游标更新的记录，之后再反转顺序
+		stmt += ` AND (s.created > ? OR (s.created = ? AND s.id > ?))
+		ORDER BY s.created ASC, s.id ASC LIMIT ?`
+		args = append(args, cursor.Created, cursor.Created, cursor.ID)
+	default:
+		stmt += ` AND (s.created < ? OR (s.created = ? AND s.id < ?))
+		ORDER BY s.created DESC, s.id DESC LIMIT ?`
+		args = append(args, cursor.Created, cursor.Created, cursor.ID)
+	}
+	args = append(args, limit+1)
 
 	// 使用Query方法在连接池去执行多结果查询
-	rows, err := m.DB.Query(stmt)
+	rows, err := m.DB.Query(stmt, args...)
 	if err != nil {
 		return nil, err
 	}
 
-	// 确保resultset总是在Latest方法返回前正确关闭,但也要确保rows不是nil
+	// 确保resultset总是在方法返回前正确关闭,但也要确保rows不是nil
 	// 因为nil执行Close会产生panic
 	// 如果resultset没有正常关闭，会耗尽连接池。
 	defer rows.Close()
@@ -82,9 +380,8 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 
 	// 使用rows.Next来迭代结果(resultset)
 	for rows.Next() {
-		s := &models.Snippet{}
-		// 同单个结果，使用Scan将属性值全部拷贝进s对象
-		err = rows.Scan(&s.ID, &s.Title, &s.Content, &s.Created, &s.Expires)
+		// 同单个结果，使用scanSnippet将属性值全部拷贝进s对象
+		s, err := scanSnippet(rows)
 		if err != nil {
 			return nil, err
 		}
@@ -97,6 +394,113 @@ func (m *SnippetModel) Latest() ([]*models.Snippet, error) {
 		return nil, err
 	}
 
-	// 如果一切正常返回切片
+	return newPage(snippets, cursor, limit), nil
+}
+
+// Search 使用FULLTEXT索引在标题和内容中搜索公开的未过期的snippet，按相关度从高到低排列
+// 跳过前offset个结果，最多返回limit个，浏览器加密的snippet只有密文，不参与搜索
+func (m *SnippetModel) Search(query string, offset, limit int) ([]*models.Snippet, error) {
+	stmt := `SELECT ` + snippetColumns + `
+	WHERE ` + listed + ` AND s.format <> '` + models.FormatEncrypted + `'
+	AND MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE)
+	ORDER BY MATCH(s.title, s.content) AGAINST(? IN NATURAL LANGUAGE MODE) DESC, s.created DESC, s.id DESC
+	LIMIT ? OFFSET ?`
+
+	rows, err := m.DB.Query(stmt, query, query, limit, offset)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	snippets := []*models.Snippet{}
+
+	for rows.Next() {
+		s, err := scanSnippet(rows)
+		if err != nil {
+			return nil, err
+		}
+		snippets = append(snippets, s)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
 	return snippets, nil
 }
+
+// newPage 根据多取了一条记录的查询结果和请求的游标，计算这一页以及上一页和下一页的游标
+func newPage(snippets []*models.Snippet, cursor *models.Cursor, limit int) *models.Page {
+	more := len(snippets) > limit
+	if more {
+		snippets = snippets[:limit]
+	}
+
+	backward := cursor != nil && cursor.Backward
+	if backward {
+		for i, j := 0, len(snippets)-1; i < j; i, j = i+1, j-1 {
+			snippets[i], snippets[j] = snippets[j], snippets[i]
+		}
+	}
+
+	page := &models.Page{Snippets: snippets}
+	if len(snippets) == 0 {
+		return page
+	}
+
+	first, last := snippets[0], snippets[len(snippets)-1]
+	// 向后翻页时，只要传入了游标就说明前面还有更新的记录，反之亦然
+	if (backward && more) || (!backward && cursor != nil) {
+		page.Prev = &models.Cursor{Created: first.Created, ID: first.ID, Backward: true}
+	}
+	if (!backward && more) || backward {
+		page.Next = &models.Cursor{Created: last.Created, ID: last.ID}
+	}
+
+	return page
+}
+
+// Revisions 返回某个snippet的所有版本，最新的版本排在最前面
+func (m *SnippetModel) Revisions(snippetID int) ([]*models.Revision, error) {
+	stmt := `SELECT snippet_id, revision, title, content, created FROM snippet_revisions
+	WHERE snippet_id = ? ORDER BY revision DESC`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	revisions := []*models.Revision{}
+
+	for rows.Next() {
+		rv := &models.Revision{}
+		err = rows.Scan(&rv.SnippetID, &rv.Number, &rv.Title, &rv.Content, &rv.Created)
+		if err != nil {
+			return nil, err
+		}
+		revisions = append(revisions, rv)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return revisions, nil
+}
+
+// Revision 返回某个snippet的指定版本
+func (m *SnippetModel) Revision(snippetID, number int) (*models.Revision, error) {
+	stmt := `SELECT snippet_id, revision, title, content, created FROM snippet_revisions
+	WHERE snippet_id = ? AND revision = ?`
+
+	rv := &models.Revision{}
+	err := m.DB.QueryRow(stmt, snippetID, number).Scan(&rv.SnippetID, &rv.Number, &rv.Title, &rv.Content, &rv.Created)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return rv, nil
+}
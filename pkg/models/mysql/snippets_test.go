@@ -0,0 +1,505 @@
+package mysql
+
+import (
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSnippetModelGet(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	tests := []struct {
+		name        string
+		snippetID   int
+		wantSnippet *models.Snippet
+		wantError   error
+	}{
+		{
+			name:      "Valid ID",
+			snippetID: 1,
+			wantSnippet: &models.Snippet{
+				ID:         1,
+				UserID:     1,
+				UserName:   "Alice Jones",
+				Title:      "An old silent pond",
+				Content:    "An old silent pond...",
+				Format:     "plain",
+				Visibility: "public",
+				Slug:       "r7Gx2kQpLm9w",
+				Created:    time.Date(2018, 12, 23, 17, 25, 22, 0, time.UTC),
+				Expires:    time.Date(2099, 12, 31, 23, 59, 59, 0, time.UTC),
+				Tags:       []string{"haiku"},
+			},
+			wantError: nil,
+		},
+		{
+			name:        "Non-existent ID",
+			snippetID:   2,
+			wantSnippet: nil,
+			wantError:   models.ErrNoRecord,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := SnippetModel{db}
+
+			// 检查连接users表后作者信息是否被正确取出
+			snippet, err := m.Get(tt.snippetID, 0)
+
+			if err != tt.wantError {
+				t.Errorf("want %v; got %s", tt.wantError, err)
+			}
+
+			if !reflect.DeepEqual(snippet, tt.wantSnippet) {
+				t.Errorf("want %v; got %v", tt.wantSnippet, snippet)
+			}
+		})
+	}
+}
+
+func TestSnippetModelInsertSlug(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		slugs     []string // newSlug依次返回的slug，第一个与snippet 1的slug重复
+		wantSlug  string
+		wantError bool
+	}{
+		{"Retry on collision", []string{"r7Gx2kQpLm9w", "b4Tn1cXeWq8z"}, "b4Tn1cXeWq8z", false},
+		{"Give up", []string{"r7Gx2kQpLm9w"}, "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			// 用完给定的slug之后一直返回最后一个
+			calls := 0
+			defer func(f func() (string, error)) { newSlug = f }(newSlug)
+			newSlug = func() (string, error) {
+				slug := tt.slugs[len(tt.slugs)-1]
+				if calls < len(tt.slugs) {
+					slug = tt.slugs[calls]
+				}
+				calls++
+				return slug, nil
+			}
+
+			m := SnippetModel{db}
+			s := &models.Snippet{UserID: 1, Title: "Title", Content: "Content", Format: "plain", Visibility: "public"}
+			id, err := m.Insert(s, "7")
+			if (err != nil) != tt.wantError {
+				t.Fatalf("want error %v; got %v", tt.wantError, err)
+			}
+			if tt.wantError {
+				if calls != slugAttempts {
+					t.Errorf("want %d attempts; got %d", slugAttempts, calls)
+				}
+				return
+			}
+
+			got, err := m.GetBySlug(tt.wantSlug, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.ID != id || s.Slug != tt.wantSlug {
+				t.Errorf("want snippet %d with slug %q; got %d and %q", id, tt.wantSlug, got.ID, s.Slug)
+			}
+		})
+	}
+}
+
+func TestSnippetModelCheckPassword(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	tests := []struct {
+		name          string
+		password      string // 创建snippet时设置的密码，为空表示没有密码
+		check         string
+		wantProtected bool
+		wantError     error
+	}{
+		{"Correct password", "open sesame", "open sesame", true, nil},
+		{"Wrong password", "open sesame", "wrong", true, models.ErrInvalidCredentials},
+		{"No password", "", "", false, models.ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := SnippetModel{db}
+			s := &models.Snippet{UserID: 1, Title: "Title", Content: "Content", Format: "plain", Visibility: "public", Password: tt.password}
+			id, err := m.Insert(s, "7")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got, err := m.Get(id, 0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got.Protected != tt.wantProtected {
+				t.Errorf("want protected %v; got %v", tt.wantProtected, got.Protected)
+			}
+
+			// 有密码的snippet不出现在列表中
+			page, err := m.ListByUser(1, nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			listed := false
+			for _, ls := range page.Snippets {
+				listed = listed || ls.ID == id
+			}
+			if listed == tt.wantProtected {
+				t.Errorf("want listed %v; got %v", !tt.wantProtected, listed)
+			}
+
+			if err := m.CheckPassword(id, tt.check); err != tt.wantError {
+				t.Errorf("want %v; got %v", tt.wantError, err)
+			}
+		})
+	}
+
+	t.Run("Non-existent ID", func(t *testing.T) {
+		db, teardown := newTestDB(t)
+		defer teardown()
+
+		m := SnippetModel{db}
+		if err := m.CheckPassword(99, "open sesame"); err != models.ErrNoRecord {
+			t.Errorf("want %v; got %v", models.ErrNoRecord, err)
+		}
+	})
+}
+
+func TestSnippetModelBurn(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		burn      bool
+		wantBurns int // 同时查看的请求中删除成功的数量
+	}{
+		{"Burn after reading", true, 1},
+		{"Ordinary snippet", false, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := SnippetModel{db}
+
+			_, err := db.Exec(`UPDATE snippets SET burn_after_reading = ? WHERE id = 1`, tt.burn)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			// 阅后即焚的snippet不出现在列表中，也不能通过id访问
+			if _, err = m.Get(1, 0); (err == nil) == tt.burn {
+				t.Errorf("Get: want found %v; got error %v", !tt.burn, err)
+			}
+			if page, _ := m.List(nil, 10); (len(page.Snippets) == 1) == tt.burn {
+				t.Errorf("List: want listed %v; got %d snippets", !tt.burn, len(page.Snippets))
+			}
+
+			// 多个请求同时删除，只能有一个成功
+			errs := make(chan error, 5)
+			for i := 0; i < cap(errs); i++ {
+				go func() { errs <- m.Burn(1) }()
+			}
+			burns := 0
+			for i := 0; i < cap(errs); i++ {
+				err := <-errs
+				switch err {
+				case nil:
+					burns++
+				case models.ErrNoRecord:
+				default:
+					t.Fatal(err)
+				}
+			}
+			if burns != tt.wantBurns {
+				t.Errorf("want %d successful burns; got %d", tt.wantBurns, burns)
+			}
+
+			_, err = m.GetBySlug("r7Gx2kQpLm9w", 0)
+			if (err == nil) == tt.burn {
+				t.Errorf("want snippet deleted %v; got error %v", tt.burn, err)
+			}
+		})
+	}
+}
+
+func TestSnippetModelVisibility(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	// snippet 1属于用户1，viewer为0表示没有登录
+	tests := []struct {
+		name       string
+		visibility string
+		viewerID   int
+		wantByID   bool
+		wantBySlug bool
+		wantListed bool
+	}{
+		{"Public", models.VisibilityPublic, 0, true, true, true},
+		{"Unlisted", models.VisibilityUnlisted, 0, false, true, false},
+		{"Unlisted owner", models.VisibilityUnlisted, 1, true, true, false},
+		{"Private", models.VisibilityPrivate, 2, false, false, false},
+		{"Private owner", models.VisibilityPrivate, 1, true, true, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := SnippetModel{db}
+
+			_, err := db.Exec(`UPDATE snippets SET visibility = ? WHERE id = 1`, tt.visibility)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			_, err = m.Get(1, tt.viewerID)
+			if got := err == nil; got != tt.wantByID {
+				t.Errorf("Get: want found %v; got error %v", tt.wantByID, err)
+			}
+			_, err = m.GetBySlug("r7Gx2kQpLm9w", tt.viewerID)
+			if got := err == nil; got != tt.wantBySlug {
+				t.Errorf("GetBySlug: want found %v; got error %v", tt.wantBySlug, err)
+			}
+
+			// 只有公开的snippet出现在列表、搜索和标签中
+			page, err := m.List(nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(page.Snippets) == 1; got != tt.wantListed {
+				t.Errorf("List: want listed %v; got %d snippets", tt.wantListed, len(page.Snippets))
+			}
+			found, err := m.Search("pond", 0, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(found) == 1; got != tt.wantListed {
+				t.Errorf("Search: want listed %v; got %d snippets", tt.wantListed, len(found))
+			}
+			page, err = m.ListByTag("haiku", nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := len(page.Snippets) == 1; got != tt.wantListed {
+				t.Errorf("ListByTag: want listed %v; got %d snippets", tt.wantListed, len(page.Snippets))
+			}
+
+			// 作者自己的列表总是包括所有的snippet
+			page, err = m.ListOwned(1, nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Snippets) != 1 {
+				t.Errorf("ListOwned: want 1 snippet; got %d", len(page.Snippets))
+			}
+			if count, _ := m.CountOwned(1); count != 1 {
+				t.Errorf("CountOwned: want 1; got %d", count)
+			}
+		})
+	}
+}
+
+// 自己的snippet数量与ListOwned一致，包括已经过期的snippet
+func TestSnippetModelCountOwnedExpired(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	if _, err := db.Exec(`UPDATE snippets SET expires = '2019-01-01 00:00:00' WHERE id = 1`); err != nil {
+		t.Fatal(err)
+	}
+
+	m := SnippetModel{db}
+	page, err := m.ListOwned(1, nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	count, err := m.CountOwned(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || count != len(page.Snippets) {
+		t.Errorf("want count 1 matching ListOwned; got %d and %d snippets", count, len(page.Snippets))
+	}
+}
+
+func TestSnippetModelSearchEncrypted(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	db, teardown := newTestDB(t)
+	defer teardown()
+
+	m := SnippetModel{db}
+
+	_, err := db.Exec(`UPDATE snippets SET format = ? WHERE id = 1`, models.FormatEncrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 加密的snippet仍然出现在列表中，但是不参与搜索
+	page, err := m.List(nil, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(page.Snippets) != 1 {
+		t.Errorf("List: want 1 snippet; got %d", len(page.Snippets))
+	}
+	found, err := m.Search("pond", 0, 10)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(found) != 0 {
+		t.Errorf("Search: want no snippets; got %d", len(found))
+	}
+}
+
+func TestSnippetModelByUser(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		userID    int
+		wantCount int
+	}{
+		{"Author", 1, 1},
+		{"No snippets", 2, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := SnippetModel{db}
+
+			count, err := m.CountByUser(tt.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != tt.wantCount {
+				t.Errorf("want count %d; got %d", tt.wantCount, count)
+			}
+
+			page, err := m.ListByUser(tt.userID, nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Snippets) != tt.wantCount {
+				t.Errorf("want %d snippets; got %d", tt.wantCount, len(page.Snippets))
+			}
+
+			// 过期的snippet只出现在ListOwned中
+			_, err = db.Exec(`UPDATE snippets SET expires = '2019-01-01 00:00:00' WHERE user_id = ?`, tt.userID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			page, err = m.ListOwned(tt.userID, nil, 10)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(page.Snippets) != tt.wantCount {
+				t.Errorf("want %d owned snippets; got %d", tt.wantCount, len(page.Snippets))
+			}
+			if count, _ := m.CountByUser(tt.userID); count != 0 {
+				t.Errorf("want expired snippets not to be counted; got %d", count)
+			}
+		})
+	}
+}
+
+// newPage不需要连接数据库，检查上一页和下一页游标的计算
+func TestNewPage(t *testing.T) {
+	// 模拟查询结果，按照查询方向排列，最多多出一条记录
+	rows := func(ids ...int) []*models.Snippet {
+		snippets := []*models.Snippet{}
+		for _, id := range ids {
+			snippets = append(snippets, &models.Snippet{ID: id, Created: time.Unix(int64(id), 0)})
+		}
+		return snippets
+	}
+	cursor := &models.Cursor{Created: time.Unix(5, 0), ID: 5}
+	backward := &models.Cursor{Created: time.Unix(5, 0), ID: 5, Backward: true}
+
+	tests := []struct {
+		name     string
+		snippets []*models.Snippet
+		cursor   *models.Cursor
+		wantIDs  []int
+		wantPrev int
+		wantNext int
+	}{
+		{"First page only", rows(3, 2, 1), nil, []int{3, 2, 1}, 0, 0},
+		{"First page with more", rows(9, 8, 7, 6), nil, []int{9, 8, 7}, 0, 7},
+		{"Middle page", rows(4, 3, 2, 1), cursor, []int{4, 3, 2}, 4, 2},
+		{"Last page", rows(4, 3), cursor, []int{4, 3}, 4, 0},
+		{"Backward with more", rows(6, 7, 8, 9), backward, []int{8, 7, 6}, 8, 6},
+		{"Backward to first page", rows(6, 7), backward, []int{7, 6}, 0, 6},
+		{"Empty", rows(), cursor, []int{}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page := newPage(tt.snippets, tt.cursor, 3)
+
+			ids := []int{}
+			for _, s := range page.Snippets {
+				ids = append(ids, s.ID)
+			}
+			if !reflect.DeepEqual(ids, tt.wantIDs) {
+				t.Errorf("want %v; got %v", tt.wantIDs, ids)
+			}
+
+			prev, next := 0, 0
+			if page.Prev != nil {
+				prev = page.Prev.ID
+				if !page.Prev.Backward {
+					t.Errorf("want prev cursor to be backward")
+				}
+			}
+			if page.Next != nil {
+				next = page.Next.ID
+			}
+			if prev != tt.wantPrev || next != tt.wantNext {
+				t.Errorf("want prev %d next %d; got prev %d next %d", tt.wantPrev, tt.wantNext, prev, next)
+			}
+		})
+	}
+}
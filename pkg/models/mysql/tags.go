@@ -0,0 +1,101 @@
+package mysql
+
+import (
+	"database/sql"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+)
+
+// setTags 在事务中把snippet的标签替换为tags，不存在的标签会被自动创建
+func setTags(tx *sql.Tx, snippetID int, tags []string) error {
+	_, err := tx.Exec(`DELETE FROM snippet_tags WHERE snippet_id = ?`, snippetID)
+	if err != nil {
+		return err
+	}
+
+	for _, name := range tags {
+		// 标签已经存在时，LAST_INSERT_ID(id)让LastInsertId返回已有标签的id
+		result, err := tx.Exec(`INSERT INTO tags (name) VALUES (?) ON DUPLICATE KEY UPDATE id = LAST_INSERT_ID(id)`, name)
+		if err != nil {
+			return err
+		}
+
+		tagID, err := result.LastInsertId()
+		if err != nil {
+			return err
+		}
+
+		_, err = tx.Exec(`INSERT INTO snippet_tags (snippet_id, tag_id) VALUES (?, ?)`, snippetID, tagID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// tags 返回某个snippet的所有标签，按名称排序
+func (m *SnippetModel) tags(snippetID int) ([]string, error) {
+	stmt := `SELECT t.name FROM tags t INNER JOIN snippet_tags st ON st.tag_id = t.id
+	WHERE st.snippet_id = ? ORDER BY t.name`
+
+	rows, err := m.DB.Query(stmt, snippetID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []string{}
+	for rows.Next() {
+		var name string
+		if err = rows.Scan(&name); err != nil {
+			return nil, err
+		}
+		tags = append(tags, name)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// ListByTag 与List相同，但只返回带有指定标签的snippet
+func (m *SnippetModel) ListByTag(tag string, cursor *models.Cursor, limit int) (*models.Page, error) {
+	where := listed + ` AND s.id IN (SELECT st.snippet_id FROM snippet_tags st
+	INNER JOIN tags t ON st.tag_id = t.id WHERE t.name = ?)`
+
+	return m.listPage(where, []interface{}{tag}, cursor, limit)
+}
+
+// TagCloud 返回被公开的未过期的snippet使用最多的limit个标签以及使用次数，按名称排序
+func (m *SnippetModel) TagCloud(limit int) ([]*models.Tag, error) {
+	stmt := `SELECT name, count FROM (
+		SELECT t.name, COUNT(*) AS count FROM tags t
+		INNER JOIN snippet_tags st ON st.tag_id = t.id
+		INNER JOIN snippets s ON st.snippet_id = s.id
+		WHERE ` + listed + `
+		GROUP BY t.id, t.name ORDER BY count DESC, t.name LIMIT ?
+	) AS top ORDER BY name`
+
+	rows, err := m.DB.Query(stmt, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*models.Tag{}
+	for rows.Next() {
+		t := &models.Tag{}
+		if err = rows.Scan(&t.Name, &t.Count); err != nil {
+			return nil, err
+		}
+		tags = append(tags, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
@@ -1,26 +1,182 @@
+CREATE TABLE users (
+        id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+        name VARCHAR(255) NOT NULL,
+        email VARCHAR(255) NOT NULL,
+        hashed_password CHAR(60) NOT NULL,
+        created DATETIME NOT NULL,
+        email_verified BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
+
 CREATE TABLE snippets (
     id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    -- 作者注销账号并选择匿名保留snippet时为NULL
+    user_id INT NULL,
     title VARCHAR(100) NOT NULL,
     content TEXT NOT NULL,
+    format VARCHAR(20) NOT NULL DEFAULT 'plain',
+    language VARCHAR(30) NOT NULL DEFAULT '',
+    -- public出现在列表中，unlisted只能通过slug访问，private只有作者可以访问
+    visibility VARCHAR(10) NOT NULL DEFAULT 'public',
+    slug CHAR(12) NOT NULL,
+    -- 阅后即焚的snippet在第一次被查看时删除
+    burn_after_reading BOOLEAN NOT NULL DEFAULT FALSE,
+    -- 查看snippet需要的密码的bcrypt哈希，没有设置密码时为NULL
+    hashed_password CHAR(60) NULL,
     created DATETIME NOT NULL,
     expires DATETIME NOT NULL
 );
 
 CREATE INDEX idx_snippets_created ON snippets(created);
+CREATE UNIQUE INDEX idx_snippets_slug ON snippets(slug);
 
-CREATE TABLE users (
-        id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
-        name VARCHAR(255) NOT NULL,
-        email VARCHAR(255) NOT NULL,
-        hashed_password CHAR(60) NOT NULL,
-        created DATETIME NOT NULL
+-- 使用ngram解析器，这样中文内容也可以被分词搜索
+ALTER TABLE snippets ADD FULLTEXT INDEX idx_snippets_fulltext (title, content) WITH PARSER ngram;
+
+ALTER TABLE snippets ADD CONSTRAINT snippets_fk_user FOREIGN KEY (user_id) REFERENCES users(id);
+
+CREATE TABLE tags (
+    id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    name VARCHAR(30) NOT NULL
 );
 
-ALTER TABLE users ADD CONSTRAINT users_uc_email UNIQUE (email);
+ALTER TABLE tags ADD CONSTRAINT tags_uc_name UNIQUE (name);
+
+CREATE TABLE snippet_tags (
+    snippet_id INT NOT NULL,
+    tag_id INT NOT NULL,
+    PRIMARY KEY (snippet_id, tag_id)
+);
+
+ALTER TABLE snippet_tags ADD CONSTRAINT snippet_tags_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE;
+
+ALTER TABLE snippet_tags ADD CONSTRAINT snippet_tags_fk_tag FOREIGN KEY (tag_id) REFERENCES tags(id) ON DELETE CASCADE;
+
+CREATE TABLE snippet_revisions (
+    id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    snippet_id INT NOT NULL,
+    revision INT NOT NULL,
+    title VARCHAR(100) NOT NULL,
+    content TEXT NOT NULL,
+    created DATETIME NOT NULL
+);
+
+ALTER TABLE snippet_revisions ADD CONSTRAINT snippet_revisions_uc_revision UNIQUE (snippet_id, revision);
+
+ALTER TABLE snippet_revisions ADD CONSTRAINT snippet_revisions_fk_snippet FOREIGN KEY (snippet_id) REFERENCES snippets(id) ON DELETE CASCADE;
+
+CREATE TABLE tokens (
+    id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    name VARCHAR(100) NOT NULL,
+    scope VARCHAR(10) NOT NULL,
+    hash BINARY(32) NOT NULL,
+    created DATETIME NOT NULL,
+    last_used DATETIME NULL
+);
+
+ALTER TABLE tokens ADD CONSTRAINT tokens_uc_hash UNIQUE (hash);
+
+ALTER TABLE tokens ADD CONSTRAINT tokens_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE password_resets (
+    id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    hash BINARY(32) NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+ALTER TABLE password_resets ADD CONSTRAINT password_resets_uc_hash UNIQUE (hash);
+
+ALTER TABLE password_resets ADD CONSTRAINT password_resets_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE two_factor (
+    user_id INT NOT NULL PRIMARY KEY,
+    secret VARCHAR(64) NOT NULL,
+    enabled BOOLEAN NOT NULL DEFAULT FALSE,
+    last_step BIGINT NOT NULL DEFAULT 0,
+    created DATETIME NOT NULL
+);
+
+ALTER TABLE two_factor ADD CONSTRAINT two_factor_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE recovery_codes (
+    id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    hash BINARY(32) NOT NULL
+);
+
+ALTER TABLE recovery_codes ADD CONSTRAINT recovery_codes_uc_hash UNIQUE (user_id, hash);
+
+ALTER TABLE recovery_codes ADD CONSTRAINT recovery_codes_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE login_failures (
+    throttle_key VARCHAR(255) NOT NULL PRIMARY KEY,
+    failures INT NOT NULL,
+    last_failure DATETIME NOT NULL
+);
+
+CREATE TABLE login_events (
+    id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    email VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    event VARCHAR(20) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_login_events_email_created ON login_events(email, created);
+
+CREATE TABLE account_activity (
+    id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+    user_id INT NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    event VARCHAR(30) NOT NULL,
+    created DATETIME NOT NULL
+);
+
+CREATE INDEX idx_account_activity_user_created ON account_activity(user_id, created);
+
+ALTER TABLE account_activity ADD CONSTRAINT account_activity_fk_user FOREIGN KEY (user_id) REFERENCES users(id) ON DELETE CASCADE;
+
+CREATE TABLE sessions (
+    id CHAR(64) NOT NULL PRIMARY KEY,
+    user_id INT NOT NULL DEFAULT 0,
+    user_agent VARCHAR(255) NOT NULL,
+    ip VARCHAR(45) NOT NULL,
+    data BLOB NOT NULL,
+    created DATETIME NOT NULL,
+    last_seen DATETIME NOT NULL,
+    expires DATETIME NOT NULL
+);
+
+CREATE INDEX idx_sessions_user ON sessions(user_id);
+CREATE INDEX idx_sessions_expires ON sessions(expires);
 
 INSERT INTO users (name, email, hashed_password, created) VALUES (
         'Alice Jones',
         'alice@example.com',
         '$2a$12$NuTjWXm3KKntReFwyBVHyuf/to.HEwTy.eS206TNfkGfr6HzGJSWG',
         '2018-12-23 17:25:22'
-);
\ No newline at end of file
+);
+
+INSERT INTO snippets (user_id, title, content, slug, created, expires) VALUES (
+        1,
+        'An old silent pond',
+        'An old silent pond...',
+        'r7Gx2kQpLm9w',
+        '2018-12-23 17:25:22',
+        '2099-12-31 23:59:59'
+);
+
+INSERT INTO snippet_revisions (snippet_id, revision, title, content, created) VALUES (
+        1,
+        1,
+        'An old silent pond',
+        'An old silent pond...',
+        '2018-12-23 17:25:22'
+);
+
+INSERT INTO tags (name) VALUES ('haiku');
+
+INSERT INTO snippet_tags (snippet_id, tag_id) VALUES (1, 1);
\ No newline at end of file
@@ -1,3 +1,25 @@
-DROP TABLE users;
+DROP TABLE sessions;
 
-DROP TABLE snippets;
\ No newline at end of file
+DROP TABLE account_activity;
+
+DROP TABLE login_events;
+
+DROP TABLE login_failures;
+
+DROP TABLE recovery_codes;
+
+DROP TABLE two_factor;
+
+DROP TABLE password_resets;
+
+DROP TABLE tokens;
+
+DROP TABLE snippet_tags;
+
+DROP TABLE tags;
+
+DROP TABLE snippet_revisions;
+
+DROP TABLE snippets;
+
+DROP TABLE users;
\ No newline at end of file
@@ -0,0 +1,121 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+)
+
+// tokenPrefix 让令牌容易被辨认出来，例如被意外提交到代码仓库时
+const tokenPrefix = "sbx_"
+
+type TokenModel struct {
+	DB *sql.DB
+}
+
+// hashToken 返回令牌的SHA-256哈希值，令牌本身是足够长的随机数，所以不需要bcrypt这样的慢哈希
+func hashToken(plaintext string) []byte {
+	sum := sha256.Sum256([]byte(plaintext))
+	return sum[:]
+}
+
+// generateToken 生成一个带有前缀的随机令牌，返回明文和哈希值
+func generateToken(prefix string) (string, []byte, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", nil, err
+	}
+	plaintext := prefix + base64.RawURLEncoding.EncodeToString(b)
+	return plaintext, hashToken(plaintext), nil
+}
+
+// Insert 为用户生成一个新的令牌，只保存哈希值，返回令牌明文
+func (m *TokenModel) Insert(userID int, name, scope string) (string, error) {
+	plaintext, hash, err := generateToken(tokenPrefix)
+	if err != nil {
+		return "", err
+	}
+
+	stmt := `INSERT INTO tokens (user_id, name, scope, hash, created)
+	VALUES(?, ?, ?, ?, UTC_TIMESTAMP())`
+
+	_, err = m.DB.Exec(stmt, userID, name, scope, hash)
+	if err != nil {
+		return "", err
+	}
+
+	return plaintext, nil
+}
+
+// List 返回用户的所有令牌，最新创建的排在前面
+func (m *TokenModel) List(userID int) ([]*models.Token, error) {
+	stmt := `SELECT id, user_id, name, scope, created, last_used FROM tokens
+	WHERE user_id = ? ORDER BY created DESC, id DESC`
+
+	rows, err := m.DB.Query(stmt, userID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []*models.Token{}
+	for rows.Next() {
+		t := &models.Token{}
+		var lastUsed sql.NullTime
+		err = rows.Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Created, &lastUsed)
+		if err != nil {
+			return nil, err
+		}
+		t.LastUsed = lastUsed.Time
+		tokens = append(tokens, t)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tokens, nil
+}
+
+// Delete 撤销用户的一个令牌，令牌不存在或者不属于该用户时返回ErrNoRecord
+func (m *TokenModel) Delete(userID, id int) error {
+	result, err := m.DB.Exec(`DELETE FROM tokens WHERE id = ? AND user_id = ?`, id, userID)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrNoRecord
+	}
+
+	return nil
+}
+
+// Authenticate 根据令牌明文找到对应的令牌，并记录最后使用的时间
+// 令牌不存在时返回ErrInvalidCredentials
+func (m *TokenModel) Authenticate(plaintext string) (*models.Token, error) {
+	hash := hashToken(plaintext)
+
+	t := &models.Token{}
+	var lastUsed sql.NullTime
+	stmt := `SELECT id, user_id, name, scope, created, last_used FROM tokens WHERE hash = ?`
+	err := m.DB.QueryRow(stmt, hash).Scan(&t.ID, &t.UserID, &t.Name, &t.Scope, &t.Created, &lastUsed)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrInvalidCredentials
+	} else if err != nil {
+		return nil, err
+	}
+	t.LastUsed = lastUsed.Time
+
+	_, err = m.DB.Exec(`UPDATE tokens SET last_used = UTC_TIMESTAMP() WHERE id = ?`, t.ID)
+	if err != nil {
+		return nil, err
+	}
+
+	return t, nil
+}
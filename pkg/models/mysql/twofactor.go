@@ -0,0 +1,159 @@
+package mysql
+
+import (
+	"crypto/rand"
+	"database/sql"
+	"encoding/base32"
+	"github.com/LTXWorld/codingOnLinux/GoProject/snippetbox/pkg/models"
+	"strings"
+)
+
+// recoveryCodeCount 是每次生成的恢复码数量
+const recoveryCodeCount = 10
+
+// TwoFactorModel 保存用户的TOTP密钥和一次性的恢复码
+type TwoFactorModel struct {
+	DB *sql.DB
+}
+
+// Secret 返回用户的TOTP密钥以及两步验证是否已经开启
+// 用户还没有开始设置两步验证时返回ErrNoRecord
+func (m *TwoFactorModel) Secret(userID int) (string, bool, error) {
+	var secret string
+	var enabled bool
+	stmt := `SELECT secret, enabled FROM two_factor WHERE user_id = ?`
+	err := m.DB.QueryRow(stmt, userID).Scan(&secret, &enabled)
+	if err == sql.ErrNoRows {
+		return "", false, models.ErrNoRecord
+	} else if err != nil {
+		return "", false, err
+	}
+
+	return secret, enabled, nil
+}
+
+// Begin 为用户保存一个新的密钥，但在Enable之前两步验证还没有开启
+func (m *TwoFactorModel) Begin(userID int, secret string) error {
+	stmt := `INSERT INTO two_factor (user_id, secret, enabled, last_step, created)
+	VALUES(?, ?, FALSE, 0, UTC_TIMESTAMP())
+	ON DUPLICATE KEY UPDATE secret = VALUES(secret), enabled = FALSE, last_step = 0, created = VALUES(created)`
+
+	_, err := m.DB.Exec(stmt, userID, secret)
+	return err
+}
+
+// Enable 开启两步验证并生成新的恢复码，返回恢复码明文，之前的恢复码全部失效
+// step是确认时使用的验证码所在的时间步，同一个验证码之后不能再用于登录
+func (m *TwoFactorModel) Enable(userID int, step int64) ([]string, error) {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE two_factor SET enabled = TRUE, last_step = ? WHERE user_id = ?`, step, userID)
+	if err != nil {
+		return nil, err
+	}
+	n, err := result.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if n == 0 {
+		return nil, models.ErrNoRecord
+	}
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return nil, err
+	}
+
+	codes := make([]string, 0, recoveryCodeCount)
+	for i := 0; i < recoveryCodeCount; i++ {
+		code, err := generateRecoveryCode()
+		if err != nil {
+			return nil, err
+		}
+		_, err = tx.Exec(`INSERT INTO recovery_codes (user_id, hash) VALUES(?, ?)`, userID, hashToken(code))
+		if err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+
+	return codes, tx.Commit()
+}
+
+// generateRecoveryCode 生成形如"abcde-fghij"的恢复码，方便用户抄写
+func generateRecoveryCode() (string, error) {
+	b := make([]byte, 7)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	s := strings.ToLower(base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(b))[:10]
+	return s[:5] + "-" + s[5:], nil
+}
+
+// Disable 关闭两步验证，同时删除密钥和所有恢复码
+func (m *TwoFactorModel) Disable(userID int) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(`DELETE FROM recovery_codes WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+	_, err = tx.Exec(`DELETE FROM two_factor WHERE user_id = ?`, userID)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseStep 记录一个已经使用过的时间步，如果这个时间步或者更晚的时间步已经使用过，返回ErrInvalidToken
+// 这样每个验证码只能使用一次
+func (m *TwoFactorModel) UseStep(userID int, step int64) error {
+	stmt := `UPDATE two_factor SET last_step = ? WHERE user_id = ? AND enabled = TRUE AND last_step < ?`
+	result, err := m.DB.Exec(stmt, step, userID, step)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidToken
+	}
+	return nil
+}
+
+// UseRecoveryCode 使用一个恢复码，每个恢复码只能使用一次，无效时返回ErrInvalidToken
+func (m *TwoFactorModel) UseRecoveryCode(userID int, code string) error {
+	code = strings.ToLower(strings.TrimSpace(code))
+	result, err := m.DB.Exec(`DELETE FROM recovery_codes WHERE user_id = ? AND hash = ?`, userID, hashToken(code))
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return models.ErrInvalidToken
+	}
+	return nil
+}
+
+// RecoveryCodesLeft 返回用户还没有使用的恢复码数量
+func (m *TwoFactorModel) RecoveryCodesLeft(userID int) (int, error) {
+	var n int
+	err := m.DB.QueryRow(`SELECT COUNT(*) FROM recovery_codes WHERE user_id = ?`, userID).Scan(&n)
+	return n, err
+}
@@ -12,13 +12,13 @@ type UserModel struct {
 	DB *sql.DB
 }
 
-// Insert 方法将字段信息插入到数据库表中，并且插入哈希后的密码
+// Insert 方法将字段信息插入到数据库表中，并且插入哈希后的密码，返回新用户的id
 // 同时出错后检查邮箱是否重复
-func (m *UserModel) Insert(name, email, password string) error {
+func (m *UserModel) Insert(name, email, password string) (int, error) {
 	// Create a bcrypt hash of the plain-text password明文密码的bcr哈希值
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
 	stmt := `INSERT INTO users (name, email, hashed_password, created)
@@ -26,15 +26,25 @@ func (m *UserModel) Insert(name, email, password string) error {
 
 	// Exec执行,如果返回错误，尝试断言为*mysql.MySQLError对象，可以检查错误编号是否为1062
 	// 如果是，通过检查错误是否与email重复有关
-	_, err = m.DB.Exec(stmt, name, email, string(hashedPassword))
+	result, err := m.DB.Exec(stmt, name, email, string(hashedPassword))
 	if err != nil {
-		if mysqlErr, ok := err.(*mysql.MySQLError); ok {
-			if mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users_uc_email key") {
-				return models.ErrDuplicateEmail
-			}
+		if isDuplicateEmail(err) {
+			return 0, models.ErrDuplicateEmail
 		}
+		return 0, err
 	}
-	return err
+
+	id, err := result.LastInsertId()
+	if err != nil {
+		return 0, err
+	}
+	return int(id), nil
+}
+
+// isDuplicateEmail 检查错误是否是违反了邮箱的唯一约束
+func isDuplicateEmail(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062 && strings.Contains(mysqlErr.Message, "users_uc_email key")
 }
 
 // Authenticate 方法通过给定的邮箱，密码验证用户是否存在，如果存在返回id
@@ -63,12 +73,44 @@ func (m *UserModel) Authenticate(email, password string) (int, error) {
 	return id, nil
 }
 
+// CheckPassword 检查用户当前的密码，密码错误时返回ErrInvalidCredentials
+func (m *UserModel) CheckPassword(id int, password string) error {
+	var hashedPassword []byte
+	err := m.DB.QueryRow("SELECT hashed_password FROM users WHERE id = ?", id).Scan(&hashedPassword)
+	if err == sql.ErrNoRows {
+		return models.ErrInvalidCredentials
+	} else if err != nil {
+		return err
+	}
+
+	err = bcrypt.CompareHashAndPassword(hashedPassword, []byte(password))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return models.ErrInvalidCredentials
+	}
+	return err
+}
+
 // Get 方法根据特定ID获取特定用户
 func (m *UserModel) Get(id int) (*models.User, error) {
 	s := &models.User{}
 
-	stmt := `SELECT id, name, email, created FROM users WHERE id = ?`
-	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Name, &s.Email, &s.Created)
+	stmt := `SELECT id, name, email, created, email_verified FROM users WHERE id = ?`
+	err := m.DB.QueryRow(stmt, id).Scan(&s.ID, &s.Name, &s.Email, &s.Created, &s.EmailVerified)
+	if err == sql.ErrNoRows {
+		return nil, models.ErrNoRecord
+	} else if err != nil {
+		return nil, err
+	}
+
+	return s, nil
+}
+
+// GetByEmail 根据邮箱获取用户，用于找回密码等还没有登录的场景
+func (m *UserModel) GetByEmail(email string) (*models.User, error) {
+	s := &models.User{}
+
+	stmt := `SELECT id, name, email, created, email_verified FROM users WHERE email = ?`
+	err := m.DB.QueryRow(stmt, email).Scan(&s.ID, &s.Name, &s.Email, &s.Created, &s.EmailVerified)
 	if err == sql.ErrNoRows {
 		return nil, models.ErrNoRecord
 	} else if err != nil {
@@ -94,3 +136,89 @@ func (m *UserModel) UpdatePassword(id int, newPassword string) error {
 
 	return nil
 }
+
+// UpdateName 修改用户的显示名称
+func (m *UserModel) UpdateName(id int, name string) error {
+	_, err := m.DB.Exec(`UPDATE users SET name = ? WHERE id = ?`, name, id)
+	return err
+}
+
+// UpdateEmail 修改用户的邮箱，新邮箱已经通过确认链接验证过，所以同时标记为已验证
+// 新邮箱已经被其它用户使用时返回ErrDuplicateEmail
+func (m *UserModel) UpdateEmail(id int, email string) error {
+	_, err := m.DB.Exec(`UPDATE users SET email = ?, email_verified = TRUE WHERE id = ?`, email, id)
+	if err != nil && isDuplicateEmail(err) {
+		return models.ErrDuplicateEmail
+	}
+	return err
+}
+
+// Delete 注销用户，anonymize为true时保留用户非私密的普通snippet但去掉作者信息，否则一起删除
+// 令牌、两步验证等数据通过外键级联删除，按邮箱记录的登录事件也一并删除
+func (m *UserModel) Delete(id int, anonymize bool) error {
+	tx, err := m.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRow(`SELECT email FROM users WHERE id = ? FOR UPDATE`, id).Scan(&email)
+	if err == sql.ErrNoRows {
+		return models.ErrNoRecord
+	} else if err != nil {
+		return err
+	}
+
+	if anonymize {
+		// 私密的snippet匿名化之后谁也无法访问，阅后即焚和有密码的snippet只有作者可以管理，所以仍然删除
+		_, err = tx.Exec(`DELETE FROM snippets WHERE user_id = ?
+		AND (visibility = ? OR burn_after_reading OR hashed_password IS NOT NULL)`, id, models.VisibilityPrivate)
+		if err == nil {
+			_, err = tx.Exec(`UPDATE snippets SET user_id = NULL WHERE user_id = ?`, id)
+		}
+	} else {
+		_, err = tx.Exec(`DELETE FROM snippets WHERE user_id = ?`, id)
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM login_events WHERE email = ?`, email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM users WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// VerifyEmail 把用户的邮箱标记为已经验证
+func (m *UserModel) VerifyEmail(id int) error {
+	result, err := m.DB.Exec(`UPDATE users SET email_verified = TRUE WHERE id = ?`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	// 已经验证过的用户RowsAffected为0，所以再检查一次用户是否存在
+	if n == 0 {
+		var exists bool
+		err = m.DB.QueryRow(`SELECT EXISTS(SELECT 1 FROM users WHERE id = ?)`, id).Scan(&exists)
+		if err != nil {
+			return err
+		}
+		if !exists {
+			return models.ErrNoRecord
+		}
+	}
+
+	return nil
+}
@@ -67,3 +67,113 @@ func TestUserModelGet(t *testing.T) {
 		})
 	}
 }
+
+func TestUserModelCheckPassword(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	tests := []struct {
+		name      string
+		userID    int
+		password  string
+		wantError error
+	}{
+		{"Wrong password", 1, "wrongPa$$word", models.ErrInvalidCredentials},
+		{"Non-existent ID", 2, "wrongPa$$word", models.ErrInvalidCredentials},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := UserModel{db}
+			err := m.CheckPassword(tt.userID, tt.password)
+			if err != tt.wantError {
+				t.Errorf("want %v; got %v", tt.wantError, err)
+			}
+		})
+	}
+}
+
+func TestUserModelDeleteAnonymizeOwnerOnly(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	// 这些snippet只有作者可以管理，匿名化之后没有人能够访问或者删除，所以随账号一起删除
+	tests := []struct {
+		name string
+		stmt string
+	}{
+		{"Private", `UPDATE snippets SET visibility = 'private' WHERE id = 1`},
+		{"Burn after reading", `UPDATE snippets SET burn_after_reading = TRUE WHERE id = 1`},
+		{"Protected", `UPDATE snippets SET hashed_password = 'x' WHERE id = 1`},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			if _, err := db.Exec(tt.stmt); err != nil {
+				t.Fatal(err)
+			}
+			if err := (&UserModel{db}).Delete(1, true); err != nil {
+				t.Fatal(err)
+			}
+
+			var exists bool
+			err := db.QueryRow(`SELECT EXISTS(SELECT 1 FROM snippets WHERE id = 1)`).Scan(&exists)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists {
+				t.Error("want snippet to be deleted")
+			}
+		})
+	}
+}
+
+func TestUserModelDelete(t *testing.T) {
+	if testing.Short() {
+		t.Skip("mysql: skipping integration test")
+	}
+
+	tests := []struct {
+		name        string
+		anonymize   bool
+		wantSnippet *models.Snippet
+		wantError   error
+	}{
+		{"Delete snippets", false, nil, models.ErrNoRecord},
+		{"Anonymize snippets", true, &models.Snippet{ID: 1, UserID: 0, UserName: models.AnonymousAuthor}, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			db, teardown := newTestDB(t)
+			defer teardown()
+
+			m := UserModel{db}
+			if err := m.Delete(1, tt.anonymize); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := m.Get(1); err != models.ErrNoRecord {
+				t.Errorf("want user to be deleted; got %v", err)
+			}
+			if err := m.Delete(1, tt.anonymize); err != models.ErrNoRecord {
+				t.Errorf("want %v deleting twice; got %v", models.ErrNoRecord, err)
+			}
+
+			s, err := (&SnippetModel{db}).Get(1, 0)
+			if err != tt.wantError {
+				t.Fatalf("want %v; got %v", tt.wantError, err)
+			}
+			if tt.wantSnippet != nil && (s.UserID != tt.wantSnippet.UserID || s.UserName != tt.wantSnippet.UserName) {
+				t.Errorf("want author %d %q; got %d %q", tt.wantSnippet.UserID, tt.wantSnippet.UserName, s.UserID, s.UserName)
+			}
+		})
+	}
+}
@@ -0,0 +1,528 @@
+// Package sessions 实现保存在服务端的会话，cookie中只保存一个随机的会话令牌
+// 会话数据保存在Store中，因此可以列出某个用户所有的会话并随时撤销
+package sessions
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/hex"
+	"errors"
+	"log"
+	"net"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+const cookieName = "session"
+
+// touchInterval 是更新会话最后活动时间的最小间隔，避免每个请求都写一次存储
+const touchInterval = time.Minute
+
+// Record 是保存在Store中的一个会话
+// ID是cookie中令牌的SHA-256摘要，存储中不保存令牌本身，泄露的数据不能直接用来冒充用户
+type Record struct {
+	ID        string
+	UserID    int
+	UserAgent string
+	IP        string
+	Data      []byte
+	Created   time.Time
+	LastSeen  time.Time
+	Expires   time.Time
+}
+
+// ErrNotFound 在更新的会话已经不存在时返回，例如会话在请求处理期间被撤销
+var ErrNotFound = errors.New("sessions: session not found")
+
+// Store 保存会话记录，Find在会话不存在或者已经过期时返回nil
+type Store interface {
+	Find(id string) (*Record, error)
+	// Insert 保存一个新的会话
+	Insert(rec *Record) error
+	// Update 更新已有的会话，会话不存在时返回ErrNotFound，不能重新创建已经撤销的会话
+	Update(rec *Record) error
+	Delete(id string) error
+	// List 返回用户所有未过期的会话，最近活动的排在前面
+	List(userID int) ([]*Record, error)
+	// DeleteUser 删除用户除了except之外的所有会话
+	DeleteUser(userID int, except string) error
+	// DeleteExpired 删除所有已经过期的会话，由Cleanup定期调用
+	DeleteExpired() error
+}
+
+// Session 保存会话的配置，用法和golangcollege/sessions相同
+type Session struct {
+	Store Store
+	// Lifetime 是会话从创建开始的有效时间，默认24小时
+	Lifetime time.Duration
+	// UserKey 是会话数据中保存已登录用户ID的键，保存会话时会同时记录在Record.UserID中
+	UserKey  string
+	Secure   bool
+	SameSite http.SameSite
+	// ErrorHandler 处理读取或保存会话时出现的错误，默认返回500
+	ErrorHandler func(http.ResponseWriter, *http.Request, error)
+}
+
+func New(store Store) *Session {
+	return &Session{
+		Store:        store,
+		Lifetime:     24 * time.Hour,
+		SameSite:     http.SameSiteLaxMode,
+		ErrorHandler: defaultErrorHandler,
+	}
+}
+
+// state 是一个请求中会话的状态，保存在请求上下文中
+type state struct {
+	mu        sync.Mutex
+	token     string
+	rec       *Record
+	values    map[string]interface{}
+	modified  bool
+	destroyed bool
+}
+
+type contextKey string
+
+var contextKeyState = contextKey("state")
+
+// hashToken 返回令牌的摘要，作为会话在存储中的ID
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func newToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// Enable 是加载和保存会话的中间件
+// 响应会先缓存起来，等处理器返回之后保存会话并设置cookie，再写出响应
+// 需要流式输出的处理器可以调用Flush提前保存会话
+func (s *Session) Enable(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		st, err := s.load(r)
+		if err != nil {
+			s.ErrorHandler(w, r, err)
+			return
+		}
+		r = r.WithContext(context.WithValue(r.Context(), contextKeyState, st))
+
+		bw := &bufferedResponseWriter{ResponseWriter: w}
+		bw.commit = func() bool {
+			if err := s.save(w, r, st); err != nil {
+				s.ErrorHandler(w, r, err)
+				return false
+			}
+			if bw.code != 0 {
+				w.WriteHeader(bw.code)
+			}
+			w.Write(bw.buf.Bytes())
+			return true
+		}
+		next.ServeHTTP(bw, r)
+
+		if !bw.committed {
+			bw.commit()
+		}
+	})
+}
+
+func (s *Session) load(r *http.Request) (*state, error) {
+	st := &state{values: make(map[string]interface{})}
+
+	cookie, err := r.Cookie(cookieName)
+	if err != nil {
+		return st, nil
+	}
+
+	rec, err := s.Store.Find(hashToken(cookie.Value))
+	if err != nil {
+		return nil, err
+	}
+	if rec == nil || time.Now().After(rec.Expires) {
+		return st, nil
+	}
+
+	if err := gob.NewDecoder(bytes.NewReader(rec.Data)).Decode(&st.values); err != nil {
+		return st, nil
+	}
+	st.token = cookie.Value
+	st.rec = rec
+	return st, nil
+}
+
+func (s *Session) save(w http.ResponseWriter, r *http.Request, st *state) error {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.destroyed {
+		if st.rec != nil {
+			if err := s.Store.Delete(st.rec.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, s.cookie("", time.Unix(1, 0)))
+		return nil
+	}
+
+	now := time.Now()
+	if !st.modified {
+		// 没有修改的会话只定期更新最后活动时间
+		if st.rec == nil || now.Sub(st.rec.LastSeen) < touchInterval {
+			return nil
+		}
+	}
+
+	// 没有数据的新会话不需要保存
+	if st.rec == nil && len(st.values) == 0 {
+		return nil
+	}
+
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(st.values); err != nil {
+		return err
+	}
+
+	newSession := st.rec == nil
+	if newSession {
+		token, err := newToken()
+		if err != nil {
+			return err
+		}
+		st.token = token
+		st.rec = &Record{ID: hashToken(token), Created: now, Expires: now.Add(s.Lifetime)}
+	}
+
+	st.rec.UserID, _ = st.values[s.UserKey].(int)
+	st.rec.UserAgent = r.UserAgent()
+	st.rec.IP = clientIP(r)
+	st.rec.Data = buf.Bytes()
+	st.rec.LastSeen = now
+	if newSession {
+		if err := s.Store.Insert(st.rec); err != nil {
+			return err
+		}
+	} else if err := s.Store.Update(st.rec); err == ErrNotFound {
+		// 会话在处理请求的过程中被撤销，不再保存，同时清除客户端的cookie
+		http.SetCookie(w, s.cookie("", time.Unix(1, 0)))
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	if newSession {
+		w.Header().Add("Vary", "Cookie")
+		http.SetCookie(w, s.cookie(st.token, st.rec.Expires))
+	}
+	return nil
+}
+
+// Cleanup 每隔interval删除一次存储中过期的会话，直到stop被关闭，出现的错误写入errorLog
+func (s *Session) Cleanup(interval time.Duration, stop <-chan struct{}, errorLog *log.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := s.Store.DeleteExpired(); err != nil {
+				errorLog.Print(err)
+			}
+		case <-stop:
+			return
+		}
+	}
+}
+
+func (s *Session) cookie(value string, expires time.Time) *http.Cookie {
+	c := &http.Cookie{
+		Name:     cookieName,
+		Value:    value,
+		Path:     "/",
+		Secure:   s.Secure,
+		HttpOnly: true,
+		SameSite: s.SameSite,
+		Expires:  expires,
+	}
+	if value == "" {
+		c.MaxAge = -1
+	}
+	return c
+}
+
+// clientIP 返回请求的来源IP，不信任X-Forwarded-For等可以被客户端伪造的请求头
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func getState(r *http.Request) *state {
+	st, ok := r.Context().Value(contextKeyState).(*state)
+	if !ok {
+		panic("sessions: no session data in context")
+	}
+	return st
+}
+
+// ID 返回当前会话在存储中的ID，会话还没有保存时返回空字符串
+func (s *Session) ID(r *http.Request) string {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	if st.rec == nil {
+		return ""
+	}
+	return st.rec.ID
+}
+
+// RenewToken 保留会话数据，但是在响应中换一个新的令牌并删除旧的会话
+// 登录等权限变化时调用，防止会话固定攻击
+func (s *Session) RenewToken(r *http.Request) error {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if st.rec != nil {
+		if err := s.Store.Delete(st.rec.ID); err != nil {
+			return err
+		}
+	}
+	st.rec = nil
+	st.token = ""
+	st.modified = true
+	return nil
+}
+
+func (s *Session) Put(r *http.Request, key string, val interface{}) {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.values[key] = val
+	st.modified = true
+}
+
+func (s *Session) Get(r *http.Request, key string) interface{} {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	return st.values[key]
+}
+
+func (s *Session) Pop(r *http.Request, key string) interface{} {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	val, ok := st.values[key]
+	if !ok {
+		return nil
+	}
+	delete(st.values, key)
+	st.modified = true
+	return val
+}
+
+func (s *Session) Remove(r *http.Request, key string) {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+
+	if _, ok := st.values[key]; !ok {
+		return
+	}
+	delete(st.values, key)
+	st.modified = true
+}
+
+func (s *Session) Exists(r *http.Request, key string) bool {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	_, ok := st.values[key]
+	return ok
+}
+
+// Clear 删除会话中所有的数据，会话本身仍然保留，之后可以继续Put
+func (s *Session) Clear(r *http.Request) {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.values = make(map[string]interface{})
+	st.modified = true
+}
+
+// Destroy 删除存储中的会话并清除客户端的cookie
+func (s *Session) Destroy(r *http.Request) {
+	st := getState(r)
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.values = make(map[string]interface{})
+	st.destroyed = true
+	st.modified = true
+}
+
+func (s *Session) GetString(r *http.Request, key string) string {
+	str, _ := s.Get(r, key).(string)
+	return str
+}
+
+func (s *Session) GetInt(r *http.Request, key string) int {
+	i, _ := s.Get(r, key).(int)
+	return i
+}
+
+func (s *Session) PopString(r *http.Request, key string) string {
+	str, _ := s.Pop(r, key).(string)
+	return str
+}
+
+type bufferedResponseWriter struct {
+	http.ResponseWriter
+	buf  bytes.Buffer
+	code int
+	// commit 保存会话并写出缓存的响应，保存失败时返回false，这时错误响应已经写出
+	commit    func() bool
+	committed bool
+	failed    bool
+}
+
+func (bw *bufferedResponseWriter) Write(b []byte) (int, error) {
+	switch {
+	case bw.failed:
+		return len(b), nil
+	case bw.committed:
+		return bw.ResponseWriter.Write(b)
+	default:
+		return bw.buf.Write(b)
+	}
+}
+
+func (bw *bufferedResponseWriter) WriteHeader(code int) {
+	if !bw.committed {
+		bw.code = code
+	}
+}
+
+// Flush 提前保存会话并写出已经缓存的响应，之后的写入直接发送给客户端
+// 调用Flush之后对会话的修改不会再被保存
+func (bw *bufferedResponseWriter) Flush() {
+	if !bw.committed {
+		bw.committed = true
+		bw.failed = !bw.commit()
+	}
+	if f, ok := bw.ResponseWriter.(http.Flusher); ok && !bw.failed {
+		f.Flush()
+	}
+}
+
+// Unwrap 让http.ResponseController可以使用底层ResponseWriter的方法，例如设置写入期限
+func (bw *bufferedResponseWriter) Unwrap() http.ResponseWriter {
+	return bw.ResponseWriter
+}
+
+func defaultErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
+	log.Output(2, err.Error())
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// MemoryStore 把会话保存在内存中，只适合测试或者单个进程，重启之后所有会话都会丢失
+type MemoryStore struct {
+	mu      sync.Mutex
+	records map[string]Record
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{records: make(map[string]Record)}
+}
+
+func (m *MemoryStore) Find(id string) (*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec, ok := m.records[id]
+	if !ok || time.Now().After(rec.Expires) {
+		return nil, nil
+	}
+	return &rec, nil
+}
+
+func (m *MemoryStore) Insert(rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.records[rec.ID] = *rec
+	return nil
+}
+
+func (m *MemoryStore) Update(rec *Record) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.records[rec.ID]; !ok {
+		return ErrNotFound
+	}
+	m.records[rec.ID] = *rec
+	return nil
+}
+
+func (m *MemoryStore) DeleteExpired() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for id, r := range m.records {
+		if now.After(r.Expires) {
+			delete(m.records, id)
+		}
+	}
+	return nil
+}
+
+func (m *MemoryStore) Delete(id string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	delete(m.records, id)
+	return nil
+}
+
+func (m *MemoryStore) List(userID int) ([]*Record, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	records := []*Record{}
+	for _, r := range m.records {
+		if r.UserID == userID && now.Before(r.Expires) {
+			rec := r
+			records = append(records, &rec)
+		}
+	}
+	sort.Slice(records, func(i, j int) bool {
+		return records[i].LastSeen.After(records[j].LastSeen)
+	})
+	return records, nil
+}
+
+func (m *MemoryStore) DeleteUser(userID int, except string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for id, r := range m.records {
+		if r.UserID == userID && id != except {
+			delete(m.records, id)
+		}
+	}
+	return nil
+}
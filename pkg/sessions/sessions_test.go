@@ -0,0 +1,232 @@
+package sessions
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// do 用给定的cookie发送一个请求，返回响应中的session cookie，没有设置cookie时返回nil
+func do(t *testing.T, h http.Handler, cookie *http.Cookie) (*httptest.ResponseRecorder, *http.Cookie) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("User-Agent", "test-agent")
+	if cookie != nil {
+		r.AddCookie(cookie)
+	}
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, r)
+
+	for _, c := range rr.Result().Cookies() {
+		if c.Name == cookieName {
+			return rr, c
+		}
+	}
+	return rr, nil
+}
+
+func TestSession(t *testing.T) {
+	store := NewMemoryStore()
+	s := New(store)
+	s.UserKey = "userID"
+
+	var action func(r *http.Request)
+	h := s.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action(r)
+		w.Write([]byte(s.GetString(r, "msg")))
+	}))
+
+	// 没有数据的会话不会保存，也不设置cookie
+	action = func(r *http.Request) {}
+	if _, c := do(t, h, nil); c != nil {
+		t.Fatal("want no cookie for an empty session")
+	}
+
+	action = func(r *http.Request) {
+		s.Put(r, "msg", "hello")
+		s.Put(r, "userID", 7)
+	}
+	_, cookie := do(t, h, nil)
+	if cookie == nil {
+		t.Fatal("want session cookie")
+	}
+	if strings.Contains(cookie.Value, "hello") {
+		t.Error("cookie should only contain the session token")
+	}
+
+	records, _ := store.List(7)
+	if len(records) != 1 || records[0].ID != hashToken(cookie.Value) || records[0].UserAgent != "test-agent" {
+		t.Fatalf("want one session for user 7; got %+v", records)
+	}
+
+	action = func(r *http.Request) {}
+	rr, c := do(t, h, cookie)
+	if rr.Body.String() != "hello" {
+		t.Errorf("want %q; got %q", "hello", rr.Body.String())
+	}
+	if c != nil {
+		t.Error("want no new cookie for an existing session")
+	}
+
+	// 换一个令牌之后数据保留，旧的令牌失效
+	action = func(r *http.Request) {
+		if err := s.RenewToken(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, renewed := do(t, h, cookie)
+	if renewed == nil || renewed.Value == cookie.Value {
+		t.Fatal("want a new session token")
+	}
+	action = func(r *http.Request) {}
+	if rr, _ := do(t, h, cookie); rr.Body.String() != "" {
+		t.Error("old token should no longer be valid")
+	}
+	if rr, _ := do(t, h, renewed); rr.Body.String() != "hello" {
+		t.Error("renewed session should keep its data")
+	}
+
+	// 在存储中删除会话相当于撤销
+	store.DeleteUser(7, "")
+	if rr, _ := do(t, h, renewed); rr.Body.String() != "" {
+		t.Error("revoked session should be empty")
+	}
+}
+
+func TestSessionDestroy(t *testing.T) {
+	store := NewMemoryStore()
+	s := New(store)
+
+	var action func(r *http.Request)
+	h := s.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action(r)
+	}))
+
+	action = func(r *http.Request) { s.Put(r, "msg", "hello") }
+	_, cookie := do(t, h, nil)
+
+	action = func(r *http.Request) { s.Destroy(r) }
+	_, c := do(t, h, cookie)
+	if c == nil || c.Value != "" || c.MaxAge >= 0 {
+		t.Errorf("want cookie to be cleared; got %+v", c)
+	}
+	if rec, _ := store.Find(hashToken(cookie.Value)); rec != nil {
+		t.Error("want session to be deleted from the store")
+	}
+}
+
+// 处理请求的过程中会话被撤销时，保存会话不能重新创建它
+func TestSessionRevokedDuringRequest(t *testing.T) {
+	store := NewMemoryStore()
+	s := New(store)
+	s.UserKey = "userID"
+
+	var action func(r *http.Request)
+	h := s.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		action(r)
+	}))
+
+	action = func(r *http.Request) { s.Put(r, "userID", 7) }
+	_, cookie := do(t, h, nil)
+
+	action = func(r *http.Request) {
+		store.DeleteUser(7, "")
+		s.Put(r, "msg", "hello")
+	}
+	_, c := do(t, h, cookie)
+	if c == nil || c.Value != "" || c.MaxAge >= 0 {
+		t.Errorf("want cookie to be cleared; got %+v", c)
+	}
+	if records, _ := store.List(7); len(records) != 0 {
+		t.Errorf("want revoked session to stay deleted; got %d sessions", len(records))
+	}
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := NewMemoryStore()
+	for _, rec := range []*Record{
+		{ID: "a", UserID: 1},
+		{ID: "b", UserID: 1},
+		{ID: "c", UserID: 2},
+	} {
+		rec.Expires = rec.Created.AddDate(3000, 0, 0)
+		store.Insert(rec)
+	}
+
+	if err := store.DeleteUser(1, "b"); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		id   string
+		want bool
+	}{
+		{"a", false},
+		{"b", true},
+		{"c", true},
+	}
+	for _, tt := range tests {
+		rec, _ := store.Find(tt.id)
+		if (rec != nil) != tt.want {
+			t.Errorf("Find(%q): want found %v", tt.id, tt.want)
+		}
+	}
+	if err := store.Update(&Record{ID: "a", UserID: 1}); err != ErrNotFound {
+		t.Errorf("Update of a deleted session: want %v; got %v", ErrNotFound, err)
+	}
+
+	// DeleteExpired只删除过期的会话
+	store.Insert(&Record{ID: "d", UserID: 2, Expires: time.Now().Add(-time.Minute)})
+	if err := store.DeleteExpired(); err != nil {
+		t.Fatal(err)
+	}
+	if len(store.records) != 2 {
+		t.Errorf("want 2 sessions after DeleteExpired; got %d", len(store.records))
+	}
+}
+
+func TestSessionFlush(t *testing.T) {
+	s := New(NewMemoryStore())
+
+	h := s.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.Put(r, "msg", "hello")
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("first "))
+		w.(http.Flusher).Flush()
+		w.Write([]byte("second"))
+	}))
+
+	rr, cookie := do(t, h, nil)
+	if cookie == nil {
+		t.Error("want session to be saved before the response is flushed")
+	}
+	if rr.Code != http.StatusAccepted || rr.Body.String() != "first second" || !rr.Flushed {
+		t.Errorf("want flushed 202 \"first second\"; got %d %q", rr.Code, rr.Body.String())
+	}
+}
+
+// 流式输出的处理器需要通过http.ResponseController延长写入期限
+func TestSessionResponseController(t *testing.T) {
+	s := New(NewMemoryStore())
+
+	h := s.Enable(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rc := http.NewResponseController(w)
+		if err := rc.SetWriteDeadline(time.Now().Add(time.Minute)); err != nil {
+			t.Errorf("want write deadline to be supported; got %v", err)
+		}
+		if err := rc.Flush(); err != nil {
+			t.Errorf("want flush to be supported; got %v", err)
+		}
+	}))
+
+	ts := httptest.NewServer(h)
+	defer ts.Close()
+
+	rs, err := ts.Client().Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs.Body.Close()
+}
@@ -0,0 +1,207 @@
+// Package throttle 记录连续的失败次数，失败次数超过阈值之后要求等待的时间按指数增长
+// 用于限制登录等需要防止暴力破解的操作
+package throttle
+
+import (
+	"sync"
+	"time"
+)
+
+// Entry 记录某个key连续失败的次数和最后一次失败的时间
+type Entry struct {
+	Failures int
+	Last     time.Time
+}
+
+// Store 保存每个key的失败记录，key不存在时Get返回零值
+// Incr 必须是原子的：同时失败的请求不能丢失次数，它把失败次数加一并把最后失败时间设为now，
+// 最后一次失败早于since的记录先清零，返回更新之后的记录
+// Decr 把失败次数减一但不改变最后失败时间，次数已经为0或者key不存在时什么也不做
+type Store interface {
+	Get(key string) (Entry, error)
+	Incr(key string, now, since time.Time) (Entry, error)
+	Decr(key string) error
+	Delete(key string) error
+}
+
+// Policy 描述允许失败的次数以及之后的等待时间
+type Policy struct {
+	// Free 是不需要等待的失败次数
+	Free int
+	// Base 是第一次需要等待的时间，之后每失败一次等待时间翻倍
+	Base time.Duration
+	// Max 是等待时间的上限，达到上限时相当于账号被临时锁定
+	Max time.Duration
+	// Forget 是多久没有失败之后忘记之前的失败记录
+	Forget time.Duration
+}
+
+// Delay 返回失败n次之后需要等待的时间
+func (p Policy) Delay(n int) time.Duration {
+	if n < p.Free {
+		return 0
+	}
+	d := p.Base
+	for i := p.Free; i < n; i++ {
+		d *= 2
+		if d >= p.Max {
+			return p.Max
+		}
+	}
+	if d > p.Max {
+		return p.Max
+	}
+	return d
+}
+
+// Limiter 根据Policy和Store中的失败记录判断是否需要等待
+type Limiter struct {
+	Store  Store
+	Policy Policy
+	now    func() time.Time
+}
+
+func New(store Store, policy Policy) *Limiter {
+	return &Limiter{Store: store, Policy: policy, now: time.Now}
+}
+
+// entry 返回key当前有效的失败记录，太久以前的失败会被忽略
+func (l *Limiter) entry(key string) (Entry, error) {
+	e, err := l.Store.Get(key)
+	if err != nil {
+		return Entry{}, err
+	}
+	if e.Failures > 0 && l.now().Sub(e.Last) > l.Policy.Forget {
+		return Entry{}, nil
+	}
+	return e, nil
+}
+
+// Wait 返回key还需要等待多久才能再次尝试，0表示现在就可以尝试
+func (l *Limiter) Wait(key string) (time.Duration, error) {
+	e, err := l.entry(key)
+	if err != nil {
+		return 0, err
+	}
+	if e.Failures == 0 {
+		return 0, nil
+	}
+
+	wait := e.Last.Add(l.Policy.Delay(e.Failures)).Sub(l.now())
+	if wait < 0 {
+		return 0, nil
+	}
+	return wait, nil
+}
+
+// Fail 记录一次失败，返回下一次尝试之前需要等待的时间
+func (l *Limiter) Fail(key string) (time.Duration, error) {
+	now := l.now()
+	e, err := l.Store.Incr(key, now, now.Add(-l.Policy.Forget))
+	if err != nil {
+		return 0, err
+	}
+	return l.Policy.Delay(e.Failures), nil
+}
+
+// Attempt 在进行代价很高的检查（例如bcrypt）之前调用，先把这次尝试原子地计为一次失败，
+// 返回还需要等待多久，0表示可以继续检查，大于0时这次尝试被拒绝，但同样计入失败次数
+// 检查失败时不需要再调用Fail，成功时调用Reset或者Refund
+func (l *Limiter) Attempt(key string) (time.Duration, error) {
+	prev, err := l.entry(key)
+	if err != nil {
+		return 0, err
+	}
+
+	now := l.now()
+	e, err := l.Store.Incr(key, now, now.Add(-l.Policy.Forget))
+	if err != nil {
+		return 0, err
+	}
+
+	// 同时到达的请求从Incr得到不同的次数，只有不超过免费次数的可以直接通过
+	delay := l.Policy.Delay(e.Failures - 1)
+	if delay == 0 {
+		return 0, nil
+	}
+	// 之后与Wait相同，从上一次失败开始计算等待时间
+	// 如果prev之后又有其它尝试，prev已经过时，不能用来计算，直接拒绝
+	if prev.Failures == e.Failures-1 && !prev.Last.Add(delay).After(now) {
+		return 0, nil
+	}
+	// 被拒绝的尝试已经计入，下一次尝试之前需要等待的时间与Fail返回的相同
+	return l.Policy.Delay(e.Failures), nil
+}
+
+// Refund 撤销一次检查成功的Attempt计入的失败，用于成功之后不应该清除之前失败记录的情况
+func (l *Limiter) Refund(key string) error {
+	return l.Store.Decr(key)
+}
+
+// Reset 清除key的失败记录，例如登录成功之后
+func (l *Limiter) Reset(key string) error {
+	return l.Store.Delete(key)
+}
+
+// MemoryStore 把失败记录保存在内存中，只适合单个进程，重启之后记录会丢失
+type MemoryStore struct {
+	mu      sync.Mutex
+	entries map[string]Entry
+	// TTL 之后没有更新的记录会被清理，防止内存无限增长
+	TTL time.Duration
+	// incrs 记录Incr的调用次数，每sweepEvery次清理一次过期的记录
+	incrs int
+}
+
+const sweepEvery = 1000
+
+func NewMemoryStore(ttl time.Duration) *MemoryStore {
+	return &MemoryStore{entries: make(map[string]Entry), TTL: ttl}
+}
+
+func (s *MemoryStore) Get(key string) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.entries[key], nil
+}
+
+func (s *MemoryStore) Incr(key string, now, since time.Time) (Entry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	e := s.entries[key]
+	if e.Last.Before(since) {
+		e.Failures = 0
+	}
+	e.Failures++
+	e.Last = now
+	s.entries[key] = e
+
+	s.incrs++
+	if s.incrs%sweepEvery == 0 {
+		for k, v := range s.entries {
+			if time.Since(v.Last) > s.TTL {
+				delete(s.entries, k)
+			}
+		}
+	}
+	return e, nil
+}
+
+func (s *MemoryStore) Decr(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if e, ok := s.entries[key]; ok && e.Failures > 0 {
+		e.Failures--
+		s.entries[key] = e
+	}
+	return nil
+}
+
+func (s *MemoryStore) Delete(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.entries, key)
+	return nil
+}
@@ -0,0 +1,165 @@
+package throttle
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestPolicyDelay(t *testing.T) {
+	p := Policy{Free: 3, Base: time.Second, Max: time.Minute}
+
+	tests := []struct {
+		failures int
+		want     time.Duration
+	}{
+		{0, 0},
+		{2, 0},
+		{3, time.Second},
+		{4, 2 * time.Second},
+		{5, 4 * time.Second},
+		{9, time.Minute},
+		{100, time.Minute},
+	}
+
+	for _, tt := range tests {
+		if got := p.Delay(tt.failures); got != tt.want {
+			t.Errorf("Delay(%d): want %v; got %v", tt.failures, tt.want, got)
+		}
+	}
+}
+
+func TestLimiter(t *testing.T) {
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	l := New(NewMemoryStore(time.Hour), Policy{Free: 2, Base: time.Second, Max: time.Minute, Forget: time.Hour})
+	l.now = func() time.Time { return now }
+
+	wait := func(want time.Duration) {
+		t.Helper()
+		got, err := l.Wait("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("want wait %v; got %v", want, got)
+		}
+	}
+
+	// 前两次失败不需要等待
+	l.Fail("alice")
+	wait(0)
+	l.Fail("alice")
+	wait(time.Second)
+
+	// 等待时间过去之后可以再次尝试，再次失败后等待时间翻倍
+	now = now.Add(time.Second)
+	wait(0)
+	l.Fail("alice")
+	wait(2 * time.Second)
+
+	// 其它key不受影响
+	if got, _ := l.Wait("bob"); got != 0 {
+		t.Errorf("want other key not to wait; got %v", got)
+	}
+
+	// 很久没有失败之后忘记失败记录
+	now = now.Add(2 * time.Hour)
+	l.Fail("alice")
+	wait(0)
+
+	// 成功之后清除失败记录
+	l.Fail("alice")
+	l.Reset("alice")
+	wait(0)
+}
+
+// 同时失败的请求都要被计数，否则并发的猜测可以绕过等待
+func TestLimiterConcurrentFail(t *testing.T) {
+	l := New(NewMemoryStore(time.Hour), Policy{Free: 2, Base: time.Second, Max: time.Minute, Forget: time.Hour})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := l.Fail("alice"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	e, err := l.Store.Get("alice")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e.Failures != 50 {
+		t.Errorf("want 50 failures; got %d", e.Failures)
+	}
+}
+
+func TestLimiterAttempt(t *testing.T) {
+	now := time.Date(2020, 12, 17, 10, 0, 0, 0, time.UTC)
+	l := New(NewMemoryStore(time.Hour), Policy{Free: 2, Base: time.Second, Max: time.Minute, Forget: time.Hour})
+	l.now = func() time.Time { return now }
+
+	attempt := func(want time.Duration) {
+		t.Helper()
+		got, err := l.Attempt("alice")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != want {
+			t.Errorf("want wait %v; got %v", want, got)
+		}
+	}
+
+	// 前两次尝试不需要等待，之后的尝试被拒绝并且同样计入失败次数
+	attempt(0)
+	attempt(0)
+	attempt(2 * time.Second)
+
+	// 按拒绝时给出的时间等待之后可以再次尝试
+	now = now.Add(2 * time.Second)
+	attempt(0)
+
+	// 成功的尝试退还计入的次数，不影响之前的失败记录
+	l.Refund("alice")
+	if got, _ := l.Wait("alice"); got != 2*time.Second {
+		t.Errorf("want refund to keep earlier failures; got wait %v", got)
+	}
+
+	// 成功之后清除失败记录
+	l.Reset("alice")
+	attempt(0)
+}
+
+// 同时到达的尝试不能都通过检查，否则并发的猜测可以绕过等待
+func TestLimiterConcurrentAttempt(t *testing.T) {
+	l := New(NewMemoryStore(time.Hour), Policy{Free: 2, Base: time.Second, Max: time.Minute, Forget: time.Hour})
+
+	var mu sync.Mutex
+	passed := 0
+	var wg sync.WaitGroup
+	for i := 0; i < 50; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, err := l.Attempt("alice")
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			if wait == 0 {
+				mu.Lock()
+				passed++
+				mu.Unlock()
+			}
+		}()
+	}
+	wg.Wait()
+
+	if passed != 2 {
+		t.Errorf("want 2 attempts to pass; got %d", passed)
+	}
+}
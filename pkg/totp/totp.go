@@ -0,0 +1,113 @@
+// Package totp 实现RFC 6238中基于时间的一次性密码，与常见的身份验证器应用兼容
+// 使用HMAC-SHA1，30秒为一个时间步，验证码为6位数字
+package totp
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// Period 是一个时间步的长度（秒）
+	Period = 30
+	// Digits 是验证码的位数
+	Digits = 6
+	// Skew 是验证时允许前后偏差的时间步数量，用来容忍手机和服务器之间的时钟误差
+	Skew = 1
+)
+
+// encoding 是密钥使用的base32编码，身份验证器应用通常不接受填充字符
+var encoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// GenerateSecret 生成一个新的160位随机密钥，返回base32编码的结果
+func GenerateSecret() (string, error) {
+	b := make([]byte, 20)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return encoding.EncodeToString(b), nil
+}
+
+// decodeSecret 解码base32密钥，忽略大小写、空格以及填充字符
+func decodeSecret(secret string) ([]byte, error) {
+	secret = strings.ToUpper(strings.ReplaceAll(secret, " ", ""))
+	return encoding.DecodeString(strings.TrimRight(secret, "="))
+}
+
+// Step 返回t所在的时间步
+func Step(t time.Time) int64 {
+	return t.Unix() / Period
+}
+
+// code 根据RFC 4226计算某个时间步的验证码
+func code(key []byte, step int64) string {
+	var msg [8]byte
+	binary.BigEndian.PutUint64(msg[:], uint64(step))
+
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg[:])
+	sum := mac.Sum(nil)
+
+	// 动态截取：用最后一个字节的低4位作为偏移量取出4个字节
+	offset := sum[len(sum)-1] & 0x0f
+	v := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	return fmt.Sprintf("%0*d", Digits, v%1000000)
+}
+
+// Code 返回密钥在t时刻的验证码
+func Code(secret string, t time.Time) (string, error) {
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return "", err
+	}
+	return code(key, Step(t)), nil
+}
+
+// Validate 检查验证码在t时刻前后Skew个时间步内是否有效，有效时返回匹配的时间步
+// 调用方应该记录使用过的时间步，拒绝同一个时间步或者更早的验证码，防止验证码被重放
+func Validate(secret, passcode string, t time.Time) (int64, bool) {
+	passcode = strings.ReplaceAll(passcode, " ", "")
+	if len(passcode) != Digits {
+		return 0, false
+	}
+
+	key, err := decodeSecret(secret)
+	if err != nil {
+		return 0, false
+	}
+
+	current := Step(t)
+	for step := current - Skew; step <= current+Skew; step++ {
+		if subtle.ConstantTimeCompare([]byte(code(key, step)), []byte(passcode)) == 1 {
+			return step, true
+		}
+	}
+	return 0, false
+}
+
+// URL 返回身份验证器应用扫描二维码时使用的otpauth地址
+func URL(issuer, account, secret string) string {
+	v := url.Values{}
+	v.Set("secret", secret)
+	v.Set("issuer", issuer)
+	v.Set("algorithm", "SHA1")
+	v.Set("digits", fmt.Sprint(Digits))
+	v.Set("period", fmt.Sprint(Period))
+
+	u := url.URL{
+		Scheme:   "otpauth",
+		Host:     "totp",
+		Path:     "/" + issuer + ":" + account,
+		RawQuery: v.Encode(),
+	}
+	return u.String()
+}
@@ -0,0 +1,104 @@
+package totp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+// RFC 6238附录B中SHA1的测试密钥"12345678901234567890"的base32编码
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCode(t *testing.T) {
+	// RFC 6238附录B中的8位验证码取最后6位
+	tests := []struct {
+		name string
+		unix int64
+		want string
+	}{
+		{"T=59", 59, "287082"},
+		{"T=1111111109", 1111111109, "081804"},
+		{"T=1111111111", 1111111111, "050471"},
+		{"T=1234567890", 1234567890, "005924"},
+		{"T=2000000000", 2000000000, "279037"},
+		{"T=20000000000", 20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %q; got %q", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111109, 0)
+
+	tests := []struct {
+		name     string
+		secret   string
+		passcode string
+		wantStep int64
+		wantOK   bool
+	}{
+		{"Current step", rfcSecret, "081804", Step(now), true},
+		{"Lower case secret with spaces", strings.ToLower("GEZD GNBV GY3T QOJQ GEZD GNBV GY3T QOJQ"), "081804", Step(now), true},
+		{"Previous step", rfcSecret, mustCode(t, now.Add(-Period*time.Second)), Step(now) - 1, true},
+		{"Next step", rfcSecret, mustCode(t, now.Add(Period*time.Second)), Step(now) + 1, true},
+		{"Too old", rfcSecret, mustCode(t, now.Add(-2*Period*time.Second)), 0, false},
+		{"Wrong code", rfcSecret, "123456", 0, false},
+		{"Wrong length", rfcSecret, "81804", 0, false},
+		{"Invalid secret", "not base32!", "081804", 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			step, ok := Validate(tt.secret, tt.passcode, now)
+			if ok != tt.wantOK || step != tt.wantStep {
+				t.Errorf("want (%d, %v); got (%d, %v)", tt.wantStep, tt.wantOK, step, ok)
+			}
+		})
+	}
+}
+
+func mustCode(t *testing.T, at time.Time) string {
+	code, err := Code(rfcSecret, at)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return code
+}
+
+func TestGenerateSecret(t *testing.T) {
+	secret, err := GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 {
+		t.Errorf("want 32 characters; got %d", len(secret))
+	}
+
+	// 新生成的密钥可以正常计算和验证验证码
+	now := time.Now()
+	code, err := Code(secret, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := Validate(secret, code, now); !ok {
+		t.Error("want generated code to validate")
+	}
+}
+
+func TestURL(t *testing.T) {
+	got := URL("Snippetbox", "alice@example.com", "JBSWY3DPEHPK3PXP")
+	want := "otpauth://totp/Snippetbox:alice@example.com?algorithm=SHA1&digits=6&issuer=Snippetbox&period=30&secret=JBSWY3DPEHPK3PXP"
+	if got != want {
+		t.Errorf("want %q; got %q", want, got)
+	}
+}
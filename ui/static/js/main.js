@@ -5,4 +5,116 @@ for (var i = 0; i < navLinks.length; i++) {
 		link.classList.add("live");
 		break;
 	}
-}
\ No newline at end of file
+}
+
+// 浏览器加密的snippet: 内容使用AES-GCM加密，密钥只放在链接#后面，浏览器不会把它发送到服务器
+// 提交的密文格式为"v1.<IV>.<密文>"，都使用不带填充的base64url编码
+function toBase64URL(bytes) {
+	var s = "";
+	for (var i = 0; i < bytes.length; i++) {
+		s += String.fromCharCode(bytes[i]);
+	}
+	return btoa(s).replace(/\+/g, "-").replace(/\//g, "_").replace(/=+$/, "");
+}
+
+function fromBase64URL(value) {
+	var s = atob(value.replace(/-/g, "+").replace(/_/g, "/"));
+	var bytes = new Uint8Array(s.length);
+	for (var i = 0; i < s.length; i++) {
+		bytes[i] = s.charCodeAt(i);
+	}
+	return bytes;
+}
+
+function encryptSnippet(plaintext) {
+	var iv = window.crypto.getRandomValues(new Uint8Array(12));
+	var key;
+	return window.crypto.subtle.generateKey({name: "AES-GCM", length: 256}, true, ["encrypt"]).then(function (k) {
+		key = k;
+		return window.crypto.subtle.encrypt({name: "AES-GCM", iv: iv}, key, new TextEncoder().encode(plaintext));
+	}).then(function (ciphertext) {
+		return window.crypto.subtle.exportKey("raw", key).then(function (raw) {
+			return {
+				ciphertext: "v1." + toBase64URL(iv) + "." + toBase64URL(new Uint8Array(ciphertext)),
+				key: toBase64URL(new Uint8Array(raw))
+			};
+		});
+	});
+}
+
+function decryptSnippet(ciphertext, key) {
+	var parts = ciphertext.split(".");
+	if (parts.length != 3 || parts[0] != "v1") {
+		return Promise.reject(new Error("unknown ciphertext format"));
+	}
+	// 链接中的密钥可能被修改，atob的错误也作为解密失败处理
+	return Promise.resolve().then(function () {
+		return window.crypto.subtle.importKey("raw", fromBase64URL(key), "AES-GCM", false, ["decrypt"]);
+	}).then(function (k) {
+		return window.crypto.subtle.decrypt({name: "AES-GCM", iv: fromBase64URL(parts[1])}, k, fromBase64URL(parts[2]));
+	}).then(function (plaintext) {
+		return new TextDecoder().decode(plaintext);
+	});
+}
+
+// 创建snippet时加密内容，提交到带有#密钥的地址，服务器重定向之后浏览器会保留#后面的部分
+var encryptForm = document.querySelector("form[data-encrypt]");
+if (encryptForm && window.crypto && window.crypto.subtle) {
+	encryptForm.querySelector(".encrypt-option").hidden = false;
+	encryptForm.addEventListener("submit", function (e) {
+		if (!document.getElementById("encrypt").checked) {
+			return;
+		}
+		e.preventDefault();
+		var content = encryptForm.querySelector("textarea[name=content]");
+		encryptSnippet(content.value).then(function (result) {
+			content.value = result.ciphertext;
+			var formats = encryptForm.querySelectorAll("input[name=format]");
+			for (var i = 0; i < formats.length; i++) {
+				formats[i].disabled = true;
+			}
+			var format = document.createElement("input");
+			format.type = "hidden";
+			format.name = "format";
+			format.value = "encrypted";
+			encryptForm.appendChild(format);
+			encryptForm.action = encryptForm.getAttribute("action") + "#" + result.key;
+			encryptForm.submit();
+		});
+	});
+}
+
+// 查看加密的snippet时使用链接中的密钥解密，使用textContent展示，不会被当作HTML执行
+var encrypted = document.querySelector(".encrypted[data-ciphertext]");
+if (encrypted) {
+	var encryptedStatus = encrypted.querySelector(".encrypted-status");
+	var key = window.location.hash.slice(1);
+	if (!window.crypto || !window.crypto.subtle) {
+		encryptedStatus.textContent = "浏览器不支持解密。";
+	} else if (key == "") {
+		encryptedStatus.textContent = "链接中缺少密钥，无法解密。";
+	} else {
+		decryptSnippet(encrypted.getAttribute("data-ciphertext"), key).then(function (plaintext) {
+			encrypted.querySelector("code").textContent = plaintext;
+			encrypted.querySelector("pre").hidden = false;
+			encryptedStatus.hidden = true;
+		}, function () {
+			encryptedStatus.textContent = "密钥错误，无法解密。";
+		});
+	}
+}
+
+// 提交表单和分享链接时带上#后面的密钥
+var keepHash = document.querySelectorAll("[data-keep-hash]");
+for (var i = 0; i < keepHash.length; i++) {
+	var el = keepHash[i];
+	if (window.location.hash == "") {
+		break;
+	}
+	if (el.tagName == "FORM") {
+		el.action = el.getAttribute("action") + window.location.hash;
+	} else {
+		el.href = el.getAttribute("href") + window.location.hash;
+		el.textContent = el.textContent + window.location.hash;
+	}
+}